@@ -0,0 +1,75 @@
+// Package debug provides the facilities for inspecting the join points
+// woven into a running binary.
+// Unlike net/http/pprof, nothing is registered automatically.
+package debug
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// WriteJoinPoints writes the join points woven into the binary to w,
+// in the same format as `aspectgo plan`, except that the file names are
+// prefixed with the import path rather than GOPATH.
+func WriteJoinPoints(w io.Writer) error {
+	jps := aspect.JoinPoints()
+	for _, jp := range jps {
+		if _, err := fmt.Fprintln(w, jp.String()); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d join point(s)\n", len(jps))
+	return err
+}
+
+// Handler returns the handler that serves the join points woven into the
+// binary. The handler serves the text written by WriteJoinPoints, or JSON
+// if the "format" query parameter is "json".
+// The handler needs to be mounted explicitly, e.g.:
+//
+//	http.Handle("/debug/aspectgo", debug.Handler())
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			jps := aspect.JoinPoints()
+			if jps == nil {
+				jps = []aspect.JoinPoint{}
+			}
+			json.NewEncoder(w).Encode(jps)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		WriteJoinPoints(w)
+	})
+}
+
+// DumpOnSignal writes the join points to w whenever the process receives
+// one of sigs, e.g. syscall.SIGUSR1. The process continues running.
+// Note that passing SIGQUIT disables the goroutine dump of the Go runtime.
+// The returned function stops dumping.
+func DumpOnSignal(w io.Writer, sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				WriteJoinPoints(w)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -0,0 +1,52 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/aspect/rt"
+)
+
+func init() {
+	// emulates a woven call site
+	rt.RegisterCallSite("0123",
+		&rt.CallSite{Callee: "fmt.Println", Position: "example.com/foo/main.go:12:2"},
+		"ExampleAspect")
+}
+
+func TestWriteJoinPoints(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteJoinPoints(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "example.com/foo/main.go:12:2: fmt.Println (ExampleAspect) [0123]\n" +
+		"1 join point(s)\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jps []aspect.JoinPoint
+	if err := json.Unmarshal(b, &jps); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	if len(jps) != 1 || jps[0].ID != "0123" || jps[0].Aspects[0] != "ExampleAspect" {
+		t.Fatalf("unexpected join points: %s", b)
+	}
+}
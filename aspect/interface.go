@@ -1,7 +1,32 @@
 // Package aspect provides interfaces for AspectGo AOP framework.
+//
+// The woven aspects are registered by the name of the aspect type
+// (e.g. "ExampleAspect") when the woven binary starts, and they can be
+// switched at runtime with Enable, Disable and SetSampling.
+// When an aspect is disabled, the woven calls call the original functions
+// directly without executing the advice.
+// These functions return an error if the aspect is not woven.
+//
+// The initial states can be configured with environment variables:
+//
+//	ASPECTGO_ENABLE=TraceAspect,-FaultAspect
+//
+// enables TraceAspect and disables FaultAspect. "*" and "-*" denote the
+// aspects not listed. ASPECTGO_CONFIG specifies the JSON file like this:
+//
+//	{
+//		"TraceAspect": {"enabled": false},
+//		"FaultAspect": {"sampling": 0.5, "config": {"probability": "0.1"}}
+//	}
+//
+// ASPECTGO_ENABLE takes precedence over the "enabled" values in the file.
+// The "config" values are passed to Configurable aspects, and they can also
+// be retrieved by Config().
+// The woven binary panics on startup if the configuration is invalid.
 package aspect
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -17,9 +42,108 @@ type Context interface {
 	// The slices can be empty []interface{}{}, but cannot be nil.
 	Call([]interface{}) []interface{}
 
+	// CallOn calls the method joinpoint on recv instead of the original
+	// receiver, e.g. for mocking and delegation.
+	// recv needs to be of the receiver type of the method, or implement it if
+	// the receiver type is an interface. Otherwise CallOn panics with
+	// *ReceiverTypeError.
+	// CallOn panics for non-method joinpoints.
+	CallOn(recv interface{}, args []interface{}) []interface{}
+
+	// ZeroResults returns the zero values of the results of the joinpoint,
+	// for the advice that does not call the joinpoint.
+	ZeroResults() []interface{}
+
+	// Results returns the results of the joinpoint with the values.
+	// The number of the values needs to be the same as the results.
+	// Untyped constants like 0 are converted to the numeric result types
+	// if the values are representable, e.g. 300 is not converted to uint8,
+	// and nil is converted to the zero value.
+	// Results panics if a value cannot be used as the result.
+	Results(values ...interface{}) []interface{}
+
+	// ReturnError returns the results of the joinpoint with err as the last
+	// result and the zero values for the others.
+	// ReturnError panics if the last result of the joinpoint is not error.
+	ReturnError(err error) []interface{}
+
 	// Receiver returns the receiver for methods.
 	// For non-method function, it just returns nil.
 	Receiver() interface{}
+
+	// CallSite returns the static information of the call site.
+	CallSite() *CallSite
+
+	// GoContext returns the context.Context argument of the joinpoint.
+	// The second value is false if the first parameter of the joinpoint is
+	// not context.Context.
+	GoContext() (context.Context, bool)
+
+	// WithGoContext returns a copy of args with the context.Context
+	// argument replaced with c, e.g. for attaching a deadline:
+	//
+	//	ctx.Call(ctx.WithGoContext(ctx.Args(), c))
+	//
+	// WithGoContext panics if GoContext returns false, or if args is empty.
+	WithGoContext(args []interface{}, c context.Context) []interface{}
+}
+
+// CallSite is the static information of the call site of a joinpoint.
+type CallSite struct {
+	// Callee is the full name of the callee. e.g. "fmt.Println"
+	Callee string
+
+	// Position is the position of the call site in the original source,
+	// like "example.com/foo/main.go:12:2".
+	// The file name is prefixed with the import path rather than GOPATH,
+	// so that the woven binary does not depend on GOPATH.
+	Position string
+}
+
+func (cs *CallSite) String() string {
+	return fmt.Sprintf("%s (%s)", cs.Position, cs.Callee)
+}
+
+// ResultError is the error for the results of an advice that do not match
+// the results of the joinpoint.
+type ResultError struct {
+	// Aspect is the name of the aspect.
+	Aspect string
+
+	// Callee is the full name of the callee.
+	Callee string
+
+	// Expected is the result types of the callee, like "(int, error)".
+	Expected string
+
+	// Results are the results returned by the advice.
+	Results []interface{}
+
+	// Reason describes the mismatch.
+	Reason string
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("invalid results of %s for %s: expected %s, got %v: %s",
+		e.Aspect, e.Callee, e.Expected, e.Results, e.Reason)
+}
+
+// ReceiverTypeError is the panic value of Context.CallOn for a receiver of
+// an incompatible type.
+type ReceiverTypeError struct {
+	// Callee is the full name of the callee.
+	Callee string
+
+	// Expected is the receiver type of the callee.
+	Expected string
+
+	// Actual is the receiver passed to CallOn.
+	Actual interface{}
+}
+
+func (e *ReceiverTypeError) Error() string {
+	return fmt.Sprintf("cannot call %s on a receiver of type %T: expected %s",
+		e.Callee, e.Actual, e.Expected)
 }
 
 // Pointcut is the type for pointcut definition.
@@ -57,3 +181,70 @@ type Aspect interface {
 	// The slice can be empty []interface{}{}, but cannot be nil.
 	Advice(Context) []interface{}
 }
+
+// Instantiation is the policy for instantiating an aspect.
+type Instantiation int
+
+const (
+	// Singleton shares a single instance of the aspect among all the
+	// joinpoints. This is the default.
+	Singleton Instantiation = iota
+
+	// PerGoroutine creates an instance of the aspect for each goroutine.
+	// The goroutine is identified by parsing its stack trace on each call,
+	// and the instances are never released even after the goroutines exit.
+	// So PerGoroutine is suitable only for a bounded set of long-lived
+	// goroutines, such as a worker pool, and not for hot paths.
+	PerGoroutine
+
+	// PerThis creates an instance of the aspect for each pointer receiver.
+	// The instance is released after the receiver is garbage-collected, so
+	// the instance must not retain the receiver.
+	// Calls to non-method functions and nil receivers share a single
+	// instance, and a call with a non-pointer receiver gets a new instance.
+	PerThis
+
+	// PerCall creates a new instance of the aspect for each call.
+	PerCall
+)
+
+func (i Instantiation) String() string {
+	switch i {
+	case Singleton:
+		return "singleton"
+	case PerGoroutine:
+		return "pergoroutine"
+	case PerThis:
+		return "perthis"
+	case PerCall:
+		return "percall"
+	}
+	return fmt.Sprintf("Instantiation(%d)", int(i))
+}
+
+// Instantiator is an optional interface for Aspect.
+// An aspect implementing Instantiator is instantiated with the returned
+// policy rather than Singleton.
+// Instantiation is executed on runtime, only once for the first instance
+// of the aspect.
+type Instantiator interface {
+	Instantiation() Instantiation
+}
+
+// Configurable is an optional interface for Aspect.
+// Configure is executed on runtime for each new instance of the aspect, with
+// the configuration of the aspect in the file specified by the ASPECTGO_CONFIG
+// environment variable. The map is empty if the aspect is not configured.
+type Configurable interface {
+	Configure(config map[string]string)
+}
+
+// UncheckedResults is an optional interface for Aspect.
+// The results of the advice are checked against the results of the joinpoint
+// on runtime, unless the aspect implements UncheckedResults, e.g. for hot
+// paths. The unchecked results with wrong types are replaced with the zero
+// values, and the wrong number of results causes index out of range.
+// UncheckedResults is detected on compilation-time, and never called.
+type UncheckedResults interface {
+	UncheckedResults()
+}
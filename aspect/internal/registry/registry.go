@@ -0,0 +1,319 @@
+// Package registry provides the registry of the woven aspects.
+// The registry is shared by package aspect, which provides the public API,
+// and package rt, which registers the woven aspects.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// ConfigEnv is the environment variable for the path of the JSON
+	// configuration file like this:
+	//
+	//	{
+	//		"TraceAspect": {"enabled": false},
+	//		"FaultAspect": {"sampling": 0.5, "config": {"probability": "0.1"}}
+	//	}
+	ConfigEnv = "ASPECTGO_CONFIG"
+
+	// EnableEnv is the environment variable for enabling and disabling the
+	// aspects like "TraceAspect,-FaultAspect".
+	// "*" and "-*" denote the aspects not listed.
+	// EnableEnv takes precedence over ConfigEnv.
+	EnableEnv = "ASPECTGO_ENABLE"
+)
+
+// AspectConfig is the configuration of an aspect.
+type AspectConfig struct {
+	// Enabled is nil if not specified.
+	Enabled *bool `json:"enabled"`
+	// Sampling is nil if not specified.
+	Sampling *float64 `json:"sampling"`
+	// Config is passed to the aspect.
+	Config map[string]string `json:"config"`
+}
+
+// Config is the runtime configuration of the aspects.
+type Config struct {
+	// Aspects are the configurations from ConfigEnv.
+	Aspects map[string]*AspectConfig
+	// Enable is parsed from EnableEnv.
+	Enable map[string]bool
+}
+
+// ParseConfig parses the contents of the configuration file and the value
+// of EnableEnv. Both can be empty.
+func ParseConfig(file []byte, enable string) (*Config, error) {
+	c := &Config{
+		Aspects: make(map[string]*AspectConfig),
+		Enable:  make(map[string]bool),
+	}
+	if len(file) != 0 {
+		if err := json.Unmarshal(file, &c.Aspects); err != nil {
+			return nil, err
+		}
+	}
+	for name, ac := range c.Aspects {
+		if ac == nil {
+			return nil, fmt.Errorf("no configuration for aspect %s", name)
+		}
+		if ac.Sampling != nil && !validRate(*ac.Sampling) {
+			return nil, fmt.Errorf("invalid sampling rate for aspect %s: %v", name, *ac.Sampling)
+		}
+	}
+	for _, s := range strings.Split(enable, ",") {
+		s = strings.TrimSpace(s)
+		enabled := !strings.HasPrefix(s, "-")
+		name := strings.TrimLeft(s, "+-")
+		if name == "" {
+			if s != "" {
+				return nil, fmt.Errorf("invalid %s: %q", EnableEnv, enable)
+			}
+			continue
+		}
+		c.Enable[name] = enabled
+	}
+	return c, nil
+}
+
+// Rate returns the initial sampling rate of the aspect name.
+func (c *Config) Rate(name string) float64 {
+	ac := c.Aspects[name]
+	if ac == nil {
+		ac = &AspectConfig{}
+	}
+	enabled := ac.Enabled == nil || *ac.Enabled
+	e, ok := c.Enable[name]
+	if !ok {
+		e, ok = c.Enable["*"]
+	}
+	if ok {
+		enabled = e
+	}
+	switch {
+	case !enabled:
+		return 0
+	case ac.Sampling != nil:
+		return *ac.Sampling
+	}
+	return 1
+}
+
+// AspectConfig returns the configuration passed to the aspect name.
+// The returned map is non-nil.
+func (c *Config) AspectConfig(name string) map[string]string {
+	m := make(map[string]string)
+	if ac := c.Aspects[name]; ac != nil {
+		for k, v := range ac.Config {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+var (
+	configOnce sync.Once
+	config     *Config
+)
+
+// loadConfig loads the configuration from the environment variables.
+// loadConfig panics on an invalid configuration, as it is loaded on the
+// startup of the woven binary.
+func loadConfig() *Config {
+	configOnce.Do(func() {
+		var (
+			file []byte
+			err  error
+		)
+		if path := os.Getenv(ConfigEnv); path != "" {
+			file, err = ioutil.ReadFile(path)
+			if err != nil {
+				panic(fmt.Errorf("aspectgo: %s: %s", ConfigEnv, err))
+			}
+		}
+		config, err = ParseConfig(file, os.Getenv(EnableEnv))
+		if err != nil {
+			panic(fmt.Errorf("aspectgo: %s", err))
+		}
+	})
+	return config
+}
+
+// ConfigFor returns the configuration passed to the aspect name.
+func ConfigFor(name string) map[string]string {
+	return loadConfig().AspectConfig(name)
+}
+
+// Switch is the runtime switch of a woven aspect.
+// Switch is safe for concurrent use.
+type Switch struct {
+	name string
+	// rate is the bits of the float64 sampling rate.
+	// 0 denotes disabled, and 1 denotes enabled.
+	rate uint64
+}
+
+// Name returns the name of the aspect.
+func (s *Switch) Name() string {
+	return s.name
+}
+
+// Rate returns the sampling rate.
+func (s *Switch) Rate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.rate))
+}
+
+// SetRate sets the sampling rate, which needs to be in [0, 1].
+func (s *Switch) SetRate(rate float64) error {
+	if !validRate(rate) {
+		return fmt.Errorf("invalid sampling rate for aspect %s: %v", s.name, rate)
+	}
+	atomic.StoreUint64(&s.rate, math.Float64bits(rate))
+	return nil
+}
+
+func validRate(rate float64) bool {
+	return rate >= 0 && rate <= 1
+}
+
+// On reports whether the advice should be executed for a call.
+func (s *Switch) On() bool {
+	rate := s.Rate()
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+	return rand.Float64() < rate
+}
+
+var (
+	mu       sync.Mutex
+	switches = make(map[string]*Switch)
+)
+
+// Register returns the switch for the aspect name.
+// If it is not registered yet, the switch is created with the sampling rate
+// in the configuration, which is 1 (enabled) by default.
+func Register(name string) *Switch {
+	mu.Lock()
+	defer mu.Unlock()
+	if s, ok := switches[name]; ok {
+		return s
+	}
+	s := &Switch{name: name, rate: math.Float64bits(loadConfig().Rate(name))}
+	switches[name] = s
+	return s
+}
+
+// Lookup returns the switch for the aspect name.
+func Lookup(name string) (*Switch, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	s, ok := switches[name]
+	if !ok {
+		return nil, fmt.Errorf("aspect %s is not woven", name)
+	}
+	return s, nil
+}
+
+// Names returns the sorted names of the registered aspects.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range switches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JoinPoint is a woven join point.
+type JoinPoint struct {
+	ID       string
+	Callee   string
+	Position string
+	Aspects  []string
+}
+
+var joinPoints []JoinPoint
+
+// RegisterJoinPoint registers the join point.
+func RegisterJoinPoint(jp JoinPoint) {
+	mu.Lock()
+	defer mu.Unlock()
+	joinPoints = append(joinPoints, jp)
+}
+
+// JoinPoints returns the registered join points sorted by the positions.
+func JoinPoints() []JoinPoint {
+	mu.Lock()
+	defer mu.Unlock()
+	res := make([]JoinPoint, len(joinPoints))
+	copy(res, joinPoints)
+	sort.Slice(res, func(i, j int) bool {
+		fi, li, ci := splitPosition(res[i].Position)
+		fj, lj, cj := splitPosition(res[j].Position)
+		switch {
+		case fi != fj:
+			return fi < fj
+		case li != lj:
+			return li < lj
+		case ci != cj:
+			return ci < cj
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// splitPosition splits the position like "example.com/foo/main.go:12:2"
+// into the file name, the line, and the column.
+// The line and the column are 0 if missing.
+func splitPosition(pos string) (string, int, int) {
+	var nums [2]int
+	for i := 1; i >= 0; i-- {
+		j := strings.LastIndexByte(pos, ':')
+		if j < 0 {
+			break
+		}
+		n, err := strconv.Atoi(pos[j+1:])
+		if err != nil {
+			break
+		}
+		nums[i], pos = n, pos[:j]
+	}
+	if nums[0] == 0 {
+		// only the line, like "main.go:12"
+		nums[0], nums[1] = nums[1], 0
+	}
+	return pos, nums[0], nums[1]
+}
+
+var resultErrorHandler atomic.Value
+
+// SetResultErrorHandler sets the handler for the invalid results of advice.
+// nil restores the default, which panics.
+func SetResultErrorHandler(h func(error)) {
+	resultErrorHandler.Store(h)
+}
+
+// ResultErrorHandler returns the handler set by SetResultErrorHandler, or
+// nil for the default.
+func ResultErrorHandler() func(error) {
+	h, _ := resultErrorHandler.Load().(func(error))
+	return h
+}
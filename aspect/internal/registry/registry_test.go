@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	file := []byte(`{
+	"TraceAspect": {"enabled": false},
+	"FaultAspect": {"sampling": 0.5, "config": {"probability": "0.1"}},
+	"NoopAspect": {"sampling": 0.5}
+}`)
+	c, err := ParseConfig(file, "TraceAspect, -NoopAspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates := map[string]float64{
+		"TraceAspect":   1,
+		"FaultAspect":   0.5,
+		"NoopAspect":    0,
+		"UnknownAspect": 1,
+	}
+	for name, expected := range rates {
+		if rate := c.Rate(name); rate != expected {
+			t.Fatalf("%s: expected %v, got %v", name, expected, rate)
+		}
+	}
+	if p := c.AspectConfig("FaultAspect")["probability"]; p != "0.1" {
+		t.Fatalf("unexpected config: %q", p)
+	}
+	if m := c.AspectConfig("UnknownAspect"); m == nil || len(m) != 0 {
+		t.Fatalf("unexpected config: %v", m)
+	}
+
+	c, err = ParseConfig(nil, "-*,TraceAspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Rate("TraceAspect") != 1 || c.Rate("FaultAspect") != 0 {
+		t.Fatalf("unexpected rates: %v", c.Enable)
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	testCases := []struct {
+		file   string
+		enable string
+	}{
+		{`{"FaultAspect": {"sampling": 2}}`, ""},
+		{`{"FaultAspect": null}`, ""},
+		{`[]`, ""},
+		{``, "TraceAspect,-"},
+	}
+	for _, tc := range testCases {
+		if _, err := ParseConfig([]byte(tc.file), tc.enable); err == nil {
+			t.Fatalf("expected an error for %q, %q", tc.file, tc.enable)
+		}
+	}
+}
+
+func TestJoinPointsSorted(t *testing.T) {
+	for _, pos := range []string{
+		"example.com/foo/main.go:12:2",
+		"example.com/foo/b.go:3:1",
+		"example.com/foo/main.go:2:10",
+		"example.com/foo/main.go:2:9",
+	} {
+		RegisterJoinPoint(JoinPoint{ID: pos, Position: pos})
+	}
+	var got []string
+	for _, jp := range JoinPoints() {
+		got = append(got, jp.Position)
+	}
+	expected := []string{
+		"example.com/foo/b.go:3:1",
+		"example.com/foo/main.go:2:9",
+		"example.com/foo/main.go:2:10",
+		"example.com/foo/main.go:12:2",
+	}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestSplitPosition(t *testing.T) {
+	testCases := []struct {
+		pos       string
+		file      string
+		line, col int
+	}{
+		{"example.com/foo/main.go:12:2", "example.com/foo/main.go", 12, 2},
+		{"main.go:12", "main.go", 12, 0},
+		{"main.go", "main.go", 0, 0},
+		{"C:/foo.go:1:2", "C:/foo.go", 1, 2},
+	}
+	for _, tc := range testCases {
+		file, line, col := splitPosition(tc.pos)
+		if file != tc.file || line != tc.line || col != tc.col {
+			t.Fatalf("%s: unexpected %s %d %d", tc.pos, file, line, col)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package fault provides the fault-injection aspect.
+//
+// The aspect can be used by embedding Aspect into an aspect in the aspect
+// file, with just the Pointcut() method:
+//
+//	type FaultAspect struct {
+//		fault.Aspect
+//	}
+//
+//	func (a *FaultAspect) Pointcut() asp.Pointcut {
+//		return asp.NewCallPointcutFromRegexp(`^example\.com/foo\.`)
+//	}
+//
+// The join points matched by the pointcut call the original functions until
+// a rule is set for them. A rule is set for the position of the call site
+// (e.g. "example.com/foo/main.go:12:2"), the callee (e.g. "example.com/foo.Open"),
+// or "*" for all the join points of the aspect, in this order of precedence.
+//
+// The rules are set at runtime with Set():
+//
+//	fault.Set("example.com/foo.Open", fault.Rule{Action: fault.ReturnError, Every: 3})
+//
+// or with the "config" of the ASPECTGO_CONFIG file, in the syntax of ParseRule():
+//
+//	{"FaultAspect": {"config": {"example.com/foo.Open": "after(10) 5% error(disk full)"}}}
+//
+// The rules set with Set() take precedence over the configuration.
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ErrInjected is the default error for ReturnError and Panic.
+var ErrInjected = errors.New("injected fault")
+
+// Action is the failure mode of a rule.
+type Action int
+
+const (
+	// Call calls the join point as usual. Call can be used for disabling
+	// the configured rule of a join point.
+	Call Action = iota
+
+	// ReturnError returns Rule.Err as the last result without calling the
+	// join point. The last result of the join point needs to be error.
+	ReturnError
+
+	// Panic panics with Rule.Err without calling the join point.
+	Panic
+
+	// Delay sleeps for Rule.Delay before calling the join point.
+	Delay
+
+	// Drop returns the zero values without calling the join point.
+	Drop
+
+	// Corrupt calls the join point, and returns the results modified with
+	// Rule.Corrupt.
+	Corrupt
+)
+
+func (a Action) String() string {
+	switch a {
+	case Call:
+		return "call"
+	case ReturnError:
+		return "error"
+	case Panic:
+		return "panic"
+	case Delay:
+		return "delay"
+	case Drop:
+		return "drop"
+	case Corrupt:
+		return "corrupt"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Rule is the rule of fault injection for a join point.
+// The triggers After, Every and Probability are combined: e.g. for After 10
+// and Every 2, the fault is injected to the 12th, 14th, 16th ... calls.
+type Rule struct {
+	Action Action
+
+	// Err is the error for ReturnError and Panic. nil means ErrInjected.
+	Err error
+
+	// Delay is the latency for Delay.
+	Delay time.Duration
+
+	// Corrupt modifies the results for Corrupt. See CorruptResult().
+	// The modified results need to have the types of the results of the join
+	// point.
+	Corrupt func(results []interface{}) []interface{}
+
+	// After skips the first After calls.
+	After uint64
+
+	// Every injects the fault to every Every-th call after the skipped calls.
+	// Zero means every call.
+	Every uint64
+
+	// Probability is the probability of the injection, between 0 and 1.
+	// Zero means 1. Use Clear() for disabling the rule.
+	Probability float64
+}
+
+func (r *Rule) err() error {
+	if r.Err == nil {
+		return ErrInjected
+	}
+	return r.Err
+}
+
+// entry is a rule with the number of the calls.
+type entry struct {
+	rule  Rule
+	calls uint64
+}
+
+// trigger counts the call and reports whether the fault is injected.
+func (e *entry) trigger() bool {
+	n := atomic.AddUint64(&e.calls, 1)
+	if n <= e.rule.After {
+		return false
+	}
+	if e.rule.Every > 1 && (n-e.rule.After)%e.rule.Every != 0 {
+		return false
+	}
+	p := e.rule.Probability
+	return p <= 0 || p >= 1 || rand.Float64() < p
+}
+
+// table is the rules for the targets.
+type table struct {
+	mu      sync.RWMutex
+	entries map[string]*entry
+}
+
+func (t *table) set(target string, r Rule) {
+	t.mu.Lock()
+	if t.entries == nil {
+		t.entries = make(map[string]*entry)
+	}
+	t.entries[target] = &entry{rule: r}
+	t.mu.Unlock()
+}
+
+func (t *table) lookup(site *aspect.CallSite) *entry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, target := range []string{site.Position, site.Callee, "*"} {
+		if e, ok := t.entries[target]; ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// rules are the rules set with Set(), which are shared among the aspects.
+var rules table
+
+// Set sets the rule for the target, which is the position of a call site, a
+// callee, or "*". The number of the calls for the triggers is reset.
+// Set is safe for concurrent use.
+func Set(target string, r Rule) {
+	rules.set(target, r)
+}
+
+// SetSpec sets the rule in the syntax of ParseRule() for the target.
+func SetSpec(target, spec string) error {
+	r, err := ParseRule(spec)
+	if err != nil {
+		return err
+	}
+	Set(target, r)
+	return nil
+}
+
+// Clear clears the rule set with Set() for the target.
+// The rule in the configuration of the aspect is used again, if any.
+func Clear(target string) {
+	rules.mu.Lock()
+	delete(rules.entries, target)
+	rules.mu.Unlock()
+}
+
+// Reset clears all the rules set with Set().
+func Reset() {
+	rules.mu.Lock()
+	rules.entries = nil
+	rules.mu.Unlock()
+}
+
+// Aspect is the fault-injection aspect. Aspect implements the Advice() method
+// of aspect.Aspect and aspect.Configurable, but not Pointcut().
+type Aspect struct {
+	// config is the rules set by Configure.
+	config table
+}
+
+// Configure implements aspect.Configurable.
+// The keys of config are the targets, and the values are the rules in the
+// syntax of ParseRule().
+// Configure panics for an invalid rule.
+func (a *Aspect) Configure(config map[string]string) {
+	for target, spec := range config {
+		r, err := ParseRule(spec)
+		if err != nil {
+			panic(fmt.Errorf("invalid fault rule for %s: %s", target, err))
+		}
+		a.config.set(target, r)
+	}
+}
+
+// Advice implements aspect.Aspect.
+func (a *Aspect) Advice(ctx aspect.Context) []interface{} {
+	site := ctx.CallSite()
+	e := rules.lookup(site)
+	if e == nil {
+		e = a.config.lookup(site)
+	}
+	if e == nil || e.rule.Action == Call || !e.trigger() {
+		return ctx.Call(ctx.Args())
+	}
+	r := &e.rule
+	switch r.Action {
+	case ReturnError:
+		return ctx.ReturnError(r.err())
+	case Panic:
+		panic(r.err())
+	case Delay:
+		time.Sleep(r.Delay)
+	case Drop:
+		return ctx.ZeroResults()
+	case Corrupt:
+		res := ctx.Call(ctx.Args())
+		if r.Corrupt == nil {
+			return res
+		}
+		return r.Corrupt(res)
+	}
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,140 @@
+package fault
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/AkihiroSuda/aspectgo/aspect/rt"
+)
+
+func newContext(callee string) *rt.ContextImpl {
+	return &rt.ContextImpl{
+		XArgs: []interface{}{6, 3},
+		XFunc: func(args []interface{}) []interface{} {
+			return []interface{}{args[0].(int) / args[1].(int), nil}
+		},
+		XCallSite:    &rt.CallSite{Callee: callee, Position: "foo/main.go:1:2"},
+		XResultTypes: []interface{}{(*int)(nil), (*error)(nil)},
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("after(10) every(2) 5% error(disk full)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Action != ReturnError || r.After != 10 || r.Every != 2 || r.Probability != 0.05 ||
+		r.Err.Error() != "disk full" {
+		t.Fatalf("unexpected rule: %+v", r)
+	}
+	r, err = ParseRule("delay(100ms)")
+	if err != nil || r.Action != Delay || r.Delay != 100*time.Millisecond {
+		t.Fatalf("unexpected rule: %+v (%v)", r, err)
+	}
+	r, err = ParseRule(" panic ")
+	if err != nil || r.Action != Panic || r.err() != ErrInjected {
+		t.Fatalf("unexpected rule: %+v (%v)", r, err)
+	}
+	for _, spec := range []string{
+		"",
+		"after(10)",
+		"after(x) drop",
+		"200% drop",
+		"delay",
+		"delay(1 hour)",
+		"corrupt(0)",
+		"corrupt(x=1)",
+		"explode",
+	} {
+		if _, err := ParseRule(spec); err == nil {
+			t.Fatalf("expected error for %q", spec)
+		}
+	}
+	if err := SetSpec("foo.div", "explode"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCorruptResult(t *testing.T) {
+	type myInt int
+	res := CorruptResult(0, "-1")([]interface{}{myInt(2), nil})
+	if v, ok := res[0].(myInt); !ok || v != -1 {
+		t.Fatalf("unexpected results: %#v", res)
+	}
+	for _, f := range []func(){
+		func() { CorruptResult(1, "x")([]interface{}{2, nil}) },
+		func() { CorruptResult(2, "x")([]interface{}{2, nil}) },
+		func() { CorruptResult(0, "x")([]interface{}{2, nil}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestAdvice(t *testing.T) {
+	defer Reset()
+	a := &Aspect{}
+	a.Configure(map[string]string{"foo.div": "after(1) every(2) error(disk full)"})
+	var s []string
+	for i := 0; i < 5; i++ {
+		s = append(s, fmt.Sprint(a.Advice(newContext("foo.div"))))
+	}
+	expected := "[[2 <nil>] [2 <nil>] [0 disk full] [2 <nil>] [0 disk full]]"
+	if fmt.Sprint(s) != expected {
+		t.Fatalf("unexpected results: %v", s)
+	}
+
+	// Set takes precedence over the configuration
+	Set("*", Rule{Action: Drop})
+	if res := a.Advice(newContext("foo.div")); fmt.Sprint(res) != "[0 <nil>]" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	Set("foo/main.go:1:2", Rule{Action: Corrupt, Corrupt: CorruptResult(0, "42")})
+	if res := a.Advice(newContext("foo.div")); fmt.Sprint(res) != "[42 <nil>]" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	Reset()
+	if res := a.Advice(newContext("foo.div")); fmt.Sprint(res) != "[2 <nil>]" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	if res := a.Advice(newContext("foo.mul")); fmt.Sprint(res) != "[2 <nil>]" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+
+	Set("foo.mul", Rule{Action: Delay, Delay: 10 * time.Millisecond})
+	start := time.Now()
+	a.Advice(newContext("foo.mul"))
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Fatalf("unexpected duration: %s", d)
+	}
+
+	Set("foo.mul", Rule{Action: Panic})
+	func() {
+		defer func() {
+			if p := recover(); p != ErrInjected {
+				t.Fatalf("unexpected panic: %v", p)
+			}
+		}()
+		a.Advice(newContext("foo.mul"))
+	}()
+}
+
+func TestProbability(t *testing.T) {
+	e := &entry{rule: Rule{Probability: 0.5}}
+	n := 0
+	for i := 0; i < 1000; i++ {
+		if e.trigger() {
+			n++
+		}
+	}
+	if n == 0 || n == 1000 {
+		t.Fatalf("unexpected number of injections: %d", n)
+	}
+}
@@ -0,0 +1,182 @@
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseRule parses the rule like "after(10) every(2) 5% error(disk full)",
+// which is the triggers followed by the action, separated by spaces.
+//
+// The triggers are:
+//
+//	after(N)  Rule.After
+//	every(N)  Rule.Every
+//	P%        Rule.Probability in percentage, e.g. "0.5%"
+//
+// The actions are:
+//
+//	call            Call
+//	error[(msg)]    ReturnError with errors.New(msg), or ErrInjected
+//	panic[(msg)]    Panic with errors.New(msg), or ErrInjected
+//	delay(d)        Delay for time.ParseDuration(d)
+//	drop            Drop
+//	corrupt(i=v)    Corrupt with CorruptResult(i, v)
+func ParseRule(spec string) (Rule, error) {
+	var r Rule
+	rest := strings.TrimSpace(spec)
+	for {
+		term := rest
+		if i := strings.IndexByte(rest, ' '); i >= 0 {
+			term = rest[:i]
+		}
+		ok, err := parseTrigger(&r, term)
+		if err != nil {
+			return r, fmt.Errorf("invalid fault rule %q: %s", spec, err)
+		}
+		if !ok {
+			break
+		}
+		rest = strings.TrimSpace(rest[len(term):])
+	}
+	if err := parseAction(&r, rest); err != nil {
+		return r, fmt.Errorf("invalid fault rule %q: %s", spec, err)
+	}
+	return r, nil
+}
+
+// parseTrigger parses term into r, and reports whether term is a trigger.
+func parseTrigger(r *Rule, term string) (bool, error) {
+	if strings.HasSuffix(term, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(term, "%"), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return false, fmt.Errorf("invalid probability %q", term)
+		}
+		r.Probability = p / 100
+		return true, nil
+	}
+	name, arg, ok := call(term)
+	if !ok || (name != "after" && name != "every") {
+		return false, nil
+	}
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	if name == "after" {
+		r.After = n
+	} else {
+		r.Every = n
+	}
+	return true, nil
+}
+
+func parseAction(r *Rule, s string) error {
+	name, arg, ok := call(s)
+	if !ok {
+		name = s
+	}
+	var err error
+	switch name {
+	case "call":
+		r.Action = Call
+	case "error", "panic":
+		r.Action = ReturnError
+		if name == "panic" {
+			r.Action = Panic
+		}
+		if ok {
+			r.Err = errors.New(arg)
+		}
+	case "delay":
+		r.Action = Delay
+		r.Delay, err = time.ParseDuration(arg)
+	case "drop":
+		r.Action = Drop
+	case "corrupt":
+		r.Action = Corrupt
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid corrupt %q: expected corrupt(index=value)", arg)
+		}
+		var i int
+		i, err = strconv.Atoi(kv[0])
+		r.Corrupt = CorruptResult(i, kv[1])
+	case "":
+		return errors.New("no action")
+	default:
+		return fmt.Errorf("unknown action %q", s)
+	}
+	if (name == "delay" || name == "corrupt") && !ok {
+		return fmt.Errorf("%s requires an argument", name)
+	}
+	return err
+}
+
+// call splits s like "name(arg)" into name and arg.
+func call(s string) (string, string, bool) {
+	i := strings.IndexByte(s, '(')
+	if i < 0 || !strings.HasSuffix(s, ")") {
+		return "", "", false
+	}
+	return s[:i], s[i+1 : len(s)-1], true
+}
+
+// CorruptResult returns the function for Rule.Corrupt that replaces the i-th
+// result with value, which is parsed for the type of the result.
+// The type of the result needs to be a boolean, numeric or string type.
+// The function panics if the result cannot be replaced with value.
+func CorruptResult(i int, value string) func(results []interface{}) []interface{} {
+	return func(results []interface{}) []interface{} {
+		if i < 0 || i >= len(results) {
+			panic(fmt.Errorf("cannot corrupt result %d of %d results", i, len(results)))
+		}
+		v, err := parseValue(results[i], value)
+		if err != nil {
+			panic(fmt.Errorf("cannot corrupt result %d: %s", i, err))
+		}
+		res := append([]interface{}(nil), results...)
+		res[i] = v
+		return res
+	}
+}
+
+// parseValue parses s for the type of orig.
+func parseValue(orig interface{}, s string) (interface{}, error) {
+	if orig == nil {
+		return nil, errors.New("cannot determine the type of nil")
+	}
+	t := reflect.TypeOf(orig)
+	v := reflect.New(t).Elem()
+	var err error
+	switch t.Kind() {
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 0, t.Bits())
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		n, err = strconv.ParseUint(s, 0, t.Bits())
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(s, t.Bits())
+		v.SetFloat(f)
+	case reflect.String:
+		v.SetString(s)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,253 @@
+// Package logging provides the logging aspect.
+//
+// The aspect can be used by embedding Aspect into an aspect in the aspect
+// file, with just the Pointcut() method:
+//
+//	type LogAspect struct {
+//		logging.Aspect
+//	}
+//
+//	func (a *LogAspect) Pointcut() asp.Pointcut {
+//		return asp.NewCallPointcutFromRegexp(`^example\.com/foo\.`)
+//	}
+//
+// The options are set with SetDefaults(), and can be overridden for each
+// aspect with the "config" of the ASPECTGO_CONFIG file:
+//
+//	{"LogAspect": {"config": {"args": "false", "sink": "json", "file": "/tmp/log.json"}}}
+//
+// The keys are "entry", "exit", "errors-only", "args", "results" and
+// "duration" for the booleans of Options, "sink" for "log", "slog" or "json",
+// and "file" for the file of the "json" sink. The "json" sink writes to the
+// standard error if "file" is not specified.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// Options is the options for the logging aspect.
+type Options struct {
+	// Entry enables logging the entries of the calls.
+	Entry bool
+
+	// Exit enables logging the returns of the calls.
+	// Panics are logged regardless of Exit with the stack traces, and
+	// propagated to the caller.
+	Exit bool
+
+	// ErrorsOnly limits the logged entries and returns to the calls that
+	// return a non-nil error as the last result.
+	// As the error is unknown on the entry, ErrorsOnly disables Entry.
+	ErrorsOnly bool
+
+	// Args enables logging the arguments.
+	Args bool
+
+	// Results enables logging the results.
+	Results bool
+
+	// Duration enables logging the duration of the calls.
+	Duration bool
+
+	// Sink is the destination of the records.
+	// nil means LogSink with the standard logger.
+	Sink Sink
+}
+
+var (
+	defaultsMu sync.RWMutex
+	defaults   = Options{
+		Entry:    true,
+		Exit:     true,
+		Args:     true,
+		Results:  true,
+		Duration: true,
+	}
+)
+
+// SetDefaults sets the options for the aspects that are not configured with
+// the ASPECTGO_CONFIG file.
+// The default options enable all the records except ErrorsOnly, with the
+// standard logger.
+func SetDefaults(opts Options) {
+	defaultsMu.Lock()
+	defaults = opts
+	defaultsMu.Unlock()
+}
+
+// Defaults returns the options set by SetDefaults.
+func Defaults() Options {
+	defaultsMu.RLock()
+	defer defaultsMu.RUnlock()
+	return defaults
+}
+
+// Aspect is the logging aspect. Aspect implements the Advice() method of
+// aspect.Aspect and aspect.Configurable, but not Pointcut().
+type Aspect struct {
+	// opts is set by Configure. nil means Defaults().
+	opts *Options
+}
+
+// Configure implements aspect.Configurable.
+// Configure panics for an invalid configuration.
+func (a *Aspect) Configure(config map[string]string) {
+	if len(config) == 0 {
+		a.opts = nil
+		return
+	}
+	opts, err := ParseOptions(Defaults(), config)
+	if err != nil {
+		panic(err)
+	}
+	a.opts = &opts
+}
+
+// ParseOptions returns base overridden with config.
+// See the package document for the keys.
+func ParseOptions(base Options, config map[string]string) (Options, error) {
+	opts := base
+	bools := map[string]*bool{
+		"entry":       &opts.Entry,
+		"exit":        &opts.Exit,
+		"errors-only": &opts.ErrorsOnly,
+		"args":        &opts.Args,
+		"results":     &opts.Results,
+		"duration":    &opts.Duration,
+	}
+	for k, v := range config {
+		if p, ok := bools[k]; ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid logging config %q: %s", k, err)
+			}
+			*p = b
+			continue
+		}
+		switch k {
+		case "sink", "file":
+		default:
+			return opts, fmt.Errorf("unknown logging config %q", k)
+		}
+	}
+	switch sink := config["sink"]; sink {
+	case "":
+		if config["file"] != "" {
+			return opts, fmt.Errorf("logging config \"file\" requires \"sink\": \"json\"")
+		}
+	case "log":
+		opts.Sink = &LogSink{}
+	case "slog":
+		opts.Sink = &SlogSink{}
+	case "json":
+		s, err := jsonFileSink(config["file"])
+		if err != nil {
+			return opts, err
+		}
+		opts.Sink = s
+	default:
+		return opts, fmt.Errorf("unknown logging sink %q", sink)
+	}
+	return opts, nil
+}
+
+var (
+	jsonFilesMu sync.Mutex
+	// jsonFiles are the sinks opened by ParseOptions, which are shared among
+	// the aspects and kept open for the lifetime of the process.
+	jsonFiles = make(map[string]*JSONSink)
+)
+
+func jsonFileSink(name string) (*JSONSink, error) {
+	if name == "" {
+		return NewJSONSink(os.Stderr), nil
+	}
+	jsonFilesMu.Lock()
+	defer jsonFilesMu.Unlock()
+	if s, ok := jsonFiles[name]; ok {
+		return s, nil
+	}
+	s, err := OpenJSONFile(name)
+	if err != nil {
+		return nil, err
+	}
+	jsonFiles[name] = s
+	return s, nil
+}
+
+func (a *Aspect) options() Options {
+	if a.opts != nil {
+		return *a.opts
+	}
+	return Defaults()
+}
+
+// Advice implements aspect.Aspect.
+func (a *Aspect) Advice(ctx aspect.Context) []interface{} {
+	opts := a.options()
+	sink := opts.Sink
+	if sink == nil {
+		sink = &LogSink{}
+	}
+	args := ctx.Args()
+	newRecord := func(ev Event) *Record {
+		r := &Record{Event: ev, Time: time.Now(), CallSite: ctx.CallSite()}
+		if opts.Args {
+			r.Args = args
+		}
+		return r
+	}
+	if opts.Entry && !opts.ErrorsOnly {
+		sink.Log(newRecord(Entry))
+	}
+	start := time.Now()
+	returned := false
+	defer func() {
+		if returned {
+			return
+		}
+		p := recover()
+		if p == nil {
+			// runtime.Goexit
+			return
+		}
+		r := newRecord(Panic)
+		r.Panic = p
+		// debug.Stack() still includes the panicking frames here, unlike
+		// the stack trace of the re-raised panic.
+		r.Stack = debug.Stack()
+		if opts.Duration {
+			r.Duration = time.Since(start)
+		}
+		sink.Log(r)
+		panic(p)
+	}()
+	res := ctx.Call(args)
+	returned = true
+	if !opts.Exit {
+		return res
+	}
+	r := newRecord(Exit)
+	if len(res) > 0 {
+		r.Err, _ = res[len(res)-1].(error)
+	}
+	if opts.ErrorsOnly && r.Err == nil {
+		return res
+	}
+	if opts.Results {
+		r.Results = res
+	}
+	if opts.Duration {
+		r.Duration = time.Since(start)
+	}
+	sink.Log(r)
+	return res
+}
@@ -0,0 +1,149 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/AkihiroSuda/aspectgo/aspect/rt"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	records []*Record
+}
+
+func (s *recordingSink) Log(r *Record) {
+	s.mu.Lock()
+	s.records = append(s.records, r)
+	s.mu.Unlock()
+}
+
+func newContext(f func(args []interface{}) []interface{}, args ...interface{}) *rt.ContextImpl {
+	return &rt.ContextImpl{
+		XArgs:     args,
+		XFunc:     f,
+		XCallSite: &rt.CallSite{Callee: "foo.div", Position: "foo/main.go:1:2"},
+	}
+}
+
+func div(args []interface{}) []interface{} {
+	a, b := args[0].(int), args[1].(int)
+	if b == 0 {
+		return []interface{}{0, errors.New("division by zero")}
+	}
+	return []interface{}{a / b, nil}
+}
+
+func TestAdvice(t *testing.T) {
+	sink := &recordingSink{}
+	a := &Aspect{opts: &Options{Entry: true, Exit: true, Args: true, Results: true, Sink: sink}}
+	res := a.Advice(newContext(div, 6, 3))
+	if fmt.Sprint(res) != "[2 <nil>]" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	a.Advice(newContext(div, 6, 0))
+	var s []string
+	for _, r := range sink.records {
+		s = append(s, r.String())
+	}
+	expected := []string{
+		"ENTRY foo/main.go:1:2 (foo.div) args=[6 3]",
+		"EXIT foo/main.go:1:2 (foo.div) args=[6 3] results=[2 <nil>]",
+		"ENTRY foo/main.go:1:2 (foo.div) args=[6 0]",
+		`EXIT foo/main.go:1:2 (foo.div) args=[6 0] results=[0 division by zero] err="division by zero"`,
+	}
+	if strings.Join(s, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected records:\n%s", strings.Join(s, "\n"))
+	}
+}
+
+func TestAdviceErrorsOnly(t *testing.T) {
+	sink := &recordingSink{}
+	a := &Aspect{opts: &Options{Entry: true, Exit: true, ErrorsOnly: true, Sink: sink}}
+	a.Advice(newContext(div, 6, 3))
+	a.Advice(newContext(div, 6, 0))
+	if len(sink.records) != 1 || sink.records[0].Event != Exit || sink.records[0].Err == nil {
+		t.Fatalf("unexpected records: %v", sink.records)
+	}
+}
+
+func TestAdvicePanic(t *testing.T) {
+	sink := &recordingSink{}
+	a := &Aspect{opts: &Options{Sink: sink}}
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Fatalf("unexpected panic: %v", p)
+		}
+		if len(sink.records) != 1 || sink.records[0].Event != Panic ||
+			sink.records[0].Panic != "boom" {
+			t.Fatalf("unexpected records: %v", sink.records)
+		}
+		if stack := string(sink.records[0].Stack); !strings.Contains(stack, "logging.explode(") {
+			t.Fatalf("stack does not include the panicking function:\n%s", stack)
+		}
+	}()
+	a.Advice(newContext(explode))
+}
+
+func explode([]interface{}) []interface{} {
+	panic("boom")
+}
+
+func TestConfigure(t *testing.T) {
+	a := &Aspect{}
+	a.Configure(map[string]string{"args": "false", "sink": "slog"})
+	opts := a.options()
+	if opts.Args || !opts.Results {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+	if _, ok := opts.Sink.(*SlogSink); !ok {
+		t.Fatalf("unexpected sink: %T", opts.Sink)
+	}
+	a.Configure(map[string]string{})
+	if a.opts != nil {
+		t.Fatalf("unexpected options: %+v", a.opts)
+	}
+	for _, config := range []map[string]string{
+		{"args": "maybe"},
+		{"unknown": "true"},
+		{"sink": "unknown"},
+		{"file": "/tmp/foo.json"},
+	} {
+		if _, err := ParseOptions(Options{}, config); err == nil {
+			t.Fatalf("expected error for %v", config)
+		}
+	}
+}
+
+func TestSinks(t *testing.T) {
+	r := &Record{
+		Event:    Exit,
+		CallSite: &rt.CallSite{Callee: "foo.div", Position: "foo/main.go:1:2"},
+		Args:     []interface{}{6, 0},
+		Results:  []interface{}{0, errors.New("division by zero")},
+		Err:      errors.New("division by zero"),
+	}
+
+	var b bytes.Buffer
+	(&LogSink{Logger: log.New(&b, "", 0)}).Log(r)
+	if b.String() != r.String()+"\n" {
+		t.Fatalf("unexpected log: %q", b.String())
+	}
+
+	b.Reset()
+	NewJSONSink(&b).Log(r)
+	var jr jsonRecord
+	if err := json.Unmarshal(b.Bytes(), &jr); err != nil {
+		t.Fatal(err)
+	}
+	if jr.Event != "exit" || jr.Callee != "foo.div" || fmt.Sprint(jr.Args) != "[6 0]" ||
+		jr.Error != "division by zero" {
+		t.Fatalf("unexpected JSON: %s", b.String())
+	}
+}
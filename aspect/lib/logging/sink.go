@@ -0,0 +1,239 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// Event is the type of a record.
+type Event int
+
+const (
+	// Entry is the entry of a call.
+	Entry Event = iota
+
+	// Exit is the return of a call.
+	Exit
+
+	// Panic is the panic of a call.
+	Panic
+)
+
+func (ev Event) String() string {
+	switch ev {
+	case Entry:
+		return "entry"
+	case Exit:
+		return "exit"
+	case Panic:
+		return "panic"
+	}
+	return fmt.Sprintf("Event(%d)", int(ev))
+}
+
+// Record is a record of the logging aspect.
+type Record struct {
+	Event    Event
+	Time     time.Time
+	CallSite *aspect.CallSite
+
+	// Args is nil unless Options.Args is set.
+	Args []interface{}
+
+	// Results is nil unless Options.Results is set. Always nil for Entry
+	// and Panic.
+	Results []interface{}
+
+	// Duration is zero unless Options.Duration is set. Always zero for
+	// Entry.
+	Duration time.Duration
+
+	// Err is the last result of Exit if it is a non-nil error.
+	Err error
+
+	// Panic is the value of Panic.
+	Panic interface{}
+
+	// Stack is the stack trace of Panic, which includes the frames of the
+	// panicking function, as the panic is re-raised after logging.
+	Stack []byte
+}
+
+// String returns the record like
+// "EXIT example.com/foo/main.go:12:2 (example.com/foo.bar) args=[1 2] results=[3] duration=1ms",
+// without the time.
+func (r *Record) String() string {
+	s := []string{strings.ToUpper(r.Event.String()), r.CallSite.String()}
+	if r.Args != nil {
+		s = append(s, fmt.Sprintf("args=%v", r.Args))
+	}
+	if r.Results != nil {
+		s = append(s, fmt.Sprintf("results=%v", r.Results))
+	}
+	if r.Err != nil {
+		s = append(s, fmt.Sprintf("err=%q", r.Err.Error()))
+	}
+	if r.Event == Panic {
+		s = append(s, fmt.Sprintf("panic=%q", fmt.Sprint(r.Panic)))
+	}
+	if r.Duration != 0 {
+		s = append(s, fmt.Sprintf("duration=%s", r.Duration))
+	}
+	return strings.Join(s, " ")
+}
+
+// Sink is the destination of the records.
+// Log is called concurrently.
+type Sink interface {
+	Log(r *Record)
+}
+
+// LogSink writes the records to a log.Logger.
+// The stack trace of Panic follows the record on separate lines.
+type LogSink struct {
+	// Logger is the logger. nil means the standard logger.
+	Logger *log.Logger
+}
+
+// Log implements Sink.
+func (s *LogSink) Log(r *Record) {
+	l := s.Logger
+	if l == nil {
+		l = log.Default()
+	}
+	if r.Stack != nil {
+		l.Printf("%s\n%s", r.String(), r.Stack)
+		return
+	}
+	l.Print(r.String())
+}
+
+// SlogSink writes the records to a slog.Logger.
+// Exit with an error and Panic are logged at slog.LevelError, and the others
+// are logged at slog.LevelInfo.
+type SlogSink struct {
+	// Logger is the logger. nil means slog.Default().
+	Logger *slog.Logger
+}
+
+// Log implements Sink.
+func (s *SlogSink) Log(r *Record) {
+	l := s.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("callee", r.CallSite.Callee),
+		slog.String("position", r.CallSite.Position),
+	}
+	if r.Args != nil {
+		attrs = append(attrs, slog.Any("args", strs(r.Args)))
+	}
+	if r.Results != nil {
+		attrs = append(attrs, slog.Any("results", strs(r.Results)))
+	}
+	if r.Err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", r.Err.Error()))
+	}
+	if r.Event == Panic {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("panic", fmt.Sprint(r.Panic)),
+			slog.String("stack", string(r.Stack)))
+	}
+	if r.Duration != 0 {
+		attrs = append(attrs, slog.Duration("duration", r.Duration))
+	}
+	l.LogAttrs(context.Background(), level, r.Event.String(), attrs...)
+}
+
+// JSONSink writes the records as JSON lines.
+// The arguments and the results are formatted with fmt.Sprint, as they are
+// not always encodable.
+type JSONSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewJSONSink creates a JSONSink for w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenJSONFile creates a JSONSink that appends the records to the file name.
+// The file is created if it does not exist.
+func OpenJSONFile(name string) (*JSONSink, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONSink(f), nil
+}
+
+type jsonRecord struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Callee   string    `json:"callee"`
+	Position string    `json:"position"`
+	Args     []string  `json:"args,omitempty"`
+	Results  []string  `json:"results,omitempty"`
+	Duration int64     `json:"duration_ns,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Panic    string    `json:"panic,omitempty"`
+	Stack    string    `json:"stack,omitempty"`
+}
+
+// Log implements Sink.
+// Log ignores the errors of the underlying writer.
+func (s *JSONSink) Log(r *Record) {
+	jr := jsonRecord{
+		Time:     r.Time,
+		Event:    r.Event.String(),
+		Callee:   r.CallSite.Callee,
+		Position: r.CallSite.Position,
+		Args:     strs(r.Args),
+		Results:  strs(r.Results),
+		Duration: int64(r.Duration),
+	}
+	if r.Err != nil {
+		jr.Error = r.Err.Error()
+	}
+	if r.Event == Panic {
+		jr.Panic = fmt.Sprint(r.Panic)
+		jr.Stack = string(r.Stack)
+	}
+	s.mu.Lock()
+	s.enc.Encode(jr)
+	s.mu.Unlock()
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (s *JSONSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func strs(values []interface{}) []string {
+	if values == nil {
+		return nil
+	}
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+package aspect
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AkihiroSuda/aspectgo/aspect/internal/registry"
+)
+
+// Enable enables the advice of the woven aspect name.
+// The aspects are enabled by default.
+func Enable(name string) error {
+	return SetSampling(name, 1)
+}
+
+// Disable disables the advice of the woven aspect name.
+func Disable(name string) error {
+	return SetSampling(name, 0)
+}
+
+// SetSampling enables the advice of the woven aspect name for the fraction
+// rate of the calls. rate needs to be in [0, 1].
+func SetSampling(name string, rate float64) error {
+	s, err := registry.Lookup(name)
+	if err != nil {
+		return err
+	}
+	return s.SetRate(rate)
+}
+
+// Sampling returns the sampling rate of the woven aspect name.
+// It is 1 for an enabled aspect, and 0 for a disabled aspect.
+func Sampling(name string) (float64, error) {
+	s, err := registry.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return s.Rate(), nil
+}
+
+// Registered returns the sorted names of the woven aspects.
+func Registered() []string {
+	return registry.Names()
+}
+
+// Config returns the configuration of the aspect name in the file specified
+// by ASPECTGO_CONFIG. The map is empty if the aspect is not configured.
+func Config(name string) map[string]string {
+	return registry.ConfigFor(name)
+}
+
+// ResultErrorHandler handles the results of an advice that do not match the
+// results of the join point.
+// If the handler returns, the zero values are used as the results.
+type ResultErrorHandler func(err *ResultError)
+
+// SetResultErrorHandler sets the handler for the invalid results of advice.
+// The default handler panics with err. Setting nil restores the default.
+// See UncheckedResults for opting out of the check.
+func SetResultErrorHandler(h ResultErrorHandler) {
+	if h == nil {
+		registry.SetResultErrorHandler(nil)
+		return
+	}
+	registry.SetResultErrorHandler(func(err error) {
+		h(err.(*ResultError))
+	})
+}
+
+// JoinPoint is a join point woven into the binary.
+type JoinPoint struct {
+	// ID is the unique ID of the join point in the binary.
+	ID string `json:"id"`
+
+	// Callee is the full name of the callee. e.g. "fmt.Println"
+	Callee string `json:"callee"`
+
+	// Position is the position of the call site in the original source.
+	// See CallSite.Position.
+	Position string `json:"position"`
+
+	// Aspects are the names of the aspects woven into the join point.
+	Aspects []string `json:"aspects"`
+}
+
+func (jp *JoinPoint) String() string {
+	return fmt.Sprintf("%s: %s (%s) [%s]",
+		jp.Position, jp.Callee, strings.Join(jp.Aspects, ", "), jp.ID)
+}
+
+// JoinPoints returns the join points woven into the binary, sorted by the
+// positions. The join points are registered when the woven binary starts.
+func JoinPoints() []JoinPoint {
+	var res []JoinPoint
+	for _, jp := range registry.JoinPoints() {
+		res = append(res, JoinPoint{
+			ID:       jp.ID,
+			Callee:   jp.Callee,
+			Position: jp.Position,
+			Aspects:  append([]string(nil), jp.Aspects...),
+		})
+	}
+	return res
+}
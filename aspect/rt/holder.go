@@ -0,0 +1,164 @@
+package rt
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"weak"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/aspect/internal/registry"
+)
+
+// Holder holds the instances of an aspect.
+// The woven aspect package declares a Holder for each aspect, and the woven
+// code calls the advice of the instance returned by Holder.Instance(),
+// if Holder.Enabled() returns true.
+//
+// The instantiation policy is determined lazily on the first call to
+// Holder.Instance(), by calling Instantiation() of a new instance if the
+// aspect implements aspect.Instantiator. That instance is used for the first
+// call, whatever the policy is.
+//
+// The instances of PerThis aspects are keyed by weak pointers to the
+// receivers, and released after the receivers are garbage-collected.
+// The instances of PerGoroutine aspects are retained for the lifetime of the
+// process, as the exit of a goroutine cannot be observed.
+type Holder struct {
+	newFunc   func() aspect.Aspect
+	sw        *registry.Switch
+	once      sync.Once
+	policy    aspect.Instantiation
+	singleton aspect.Aspect
+	first     atomic.Pointer[aspect.Aspect]
+	instances sync.Map
+}
+
+// NewHolder creates the Holder for the aspect name, and registers the aspect
+// so that it can be switched with aspect.Enable() and aspect.Disable().
+// newFunc returns a new instance of the aspect.
+func NewHolder(name string, newFunc func() aspect.Aspect) *Holder {
+	return &Holder{
+		newFunc: newFunc,
+		sw:      registry.Register(name),
+	}
+}
+
+// Enabled reports whether the advice should be executed for the current
+// call. Enabled is safe for concurrent use.
+func (h *Holder) Enabled() bool {
+	return h.sw.On()
+}
+
+// newInstance creates a new instance of the aspect.
+// If the aspect implements aspect.Configurable, it is configured with the
+// runtime configuration.
+func (h *Holder) newInstance() aspect.Aspect {
+	asp := h.newFunc()
+	if c, ok := asp.(aspect.Configurable); ok {
+		c.Configure(registry.ConfigFor(h.sw.Name()))
+	}
+	return asp
+}
+
+func (h *Holder) init() {
+	asp := h.newInstance()
+	if inst, ok := asp.(aspect.Instantiator); ok {
+		h.policy = inst.Instantiation()
+	}
+	if h.policy == aspect.Singleton {
+		h.singleton = asp
+		return
+	}
+	h.first.Store(&asp)
+}
+
+// fresh returns the instance created by init() if it has not been used yet,
+// otherwise a new instance.
+func (h *Holder) fresh() aspect.Aspect {
+	if h.first.Load() != nil {
+		if asp := h.first.Swap(nil); asp != nil {
+			return *asp
+		}
+	}
+	return h.newInstance()
+}
+
+// Instance returns the instance of the aspect for the call with the
+// receiver recv. recv is nil for non-method functions.
+// Instance is safe for concurrent use.
+func (h *Holder) Instance(recv interface{}) aspect.Aspect {
+	h.once.Do(h.init)
+	switch h.policy {
+	case aspect.PerGoroutine:
+		return h.load(goroutineID())
+	case aspect.PerThis:
+		return h.loadThis(recv)
+	case aspect.PerCall:
+		return h.fresh()
+	}
+	return h.singleton
+}
+
+// Instantiation returns the instantiation policy of the aspect.
+func (h *Holder) Instantiation() aspect.Instantiation {
+	h.once.Do(h.init)
+	return h.policy
+}
+
+func (h *Holder) load(key interface{}) aspect.Aspect {
+	if asp, ok := h.instances.Load(key); ok {
+		return asp.(aspect.Aspect)
+	}
+	asp, _ := h.instances.LoadOrStore(key, h.fresh())
+	return asp.(aspect.Aspect)
+}
+
+// loadThis returns the instance for the receiver recv.
+// A pointer receiver is keyed by a weak pointer, and the instance is deleted
+// by a cleanup after the receiver is garbage-collected.
+// A receiver of other kinds has no identity to be keyed by, so a new instance
+// is returned for each call.
+func (h *Holder) loadThis(recv interface{}) aspect.Aspect {
+	if recv == nil {
+		return h.load(nil)
+	}
+	v := reflect.ValueOf(recv)
+	if v.Kind() != reflect.Ptr {
+		return h.fresh()
+	}
+	if v.IsNil() {
+		return h.load(nil)
+	}
+	p := (*byte)(v.UnsafePointer())
+	key := weak.Make(p)
+	if asp, ok := h.instances.Load(key); ok {
+		return asp.(aspect.Aspect)
+	}
+	asp, loaded := h.instances.LoadOrStore(key, h.fresh())
+	if !loaded {
+		runtime.AddCleanup(p, func(key weak.Pointer[byte]) {
+			h.instances.Delete(key)
+		}, key)
+	}
+	return asp.(aspect.Aspect)
+}
+
+// goroutineID returns the ID of the current goroutine, which is parsed from
+// the first line of the stack trace like "goroutine 42 [running]:".
+func goroutineID() uint64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
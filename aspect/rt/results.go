@@ -0,0 +1,228 @@
+package rt
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/aspect/internal/registry"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// resultTypes returns the types of the results.
+func (ctx *ContextImpl) resultTypes() []reflect.Type {
+	types := make([]reflect.Type, len(ctx.XResultTypes))
+	for i, p := range ctx.XResultTypes {
+		types[i] = reflect.TypeOf(p).Elem()
+	}
+	return types
+}
+
+// ZeroResults should NOT be called manually.
+func (ctx *ContextImpl) ZeroResults() []interface{} {
+	types := ctx.resultTypes()
+	res := make([]interface{}, len(types))
+	for i, t := range types {
+		res[i] = reflect.Zero(t).Interface()
+	}
+	return res
+}
+
+// Results should NOT be called manually.
+func (ctx *ContextImpl) Results(values ...interface{}) []interface{} {
+	types := ctx.resultTypes()
+	if len(values) != len(types) {
+		panic(fmt.Errorf("%s returns %d result(s), got %d",
+			ctx.XCallSite.Callee, len(types), len(values)))
+	}
+	res := make([]interface{}, len(types))
+	for i, t := range types {
+		v, err := convertResult(values[i], t)
+		if err != nil {
+			panic(fmt.Errorf("%s: result %d: %s", ctx.XCallSite.Callee, i, err))
+		}
+		res[i] = v
+	}
+	return res
+}
+
+// ReturnError should NOT be called manually.
+func (ctx *ContextImpl) ReturnError(err error) []interface{} {
+	types := ctx.resultTypes()
+	if len(types) == 0 || types[len(types)-1] != errorType {
+		panic(fmt.Errorf("%s does not return error", ctx.XCallSite.Callee))
+	}
+	res := ctx.ZeroResults()
+	res[len(res)-1] = err
+	return res
+}
+
+// convertResult converts v to t.
+func convertResult(v interface{}, t reflect.Type) (interface{}, error) {
+	if v == nil {
+		return reflect.Zero(t).Interface(), nil
+	}
+	vt := reflect.TypeOf(v)
+	switch {
+	case vt.AssignableTo(t) && t.Kind() == reflect.Interface:
+		return v, nil
+	case vt.AssignableTo(t):
+		// e.g. []int to a named slice type
+		return reflect.ValueOf(v).Convert(t).Interface(), nil
+	case isNumeric(vt) && isNumeric(t) && representable(reflect.ValueOf(v), t):
+		return reflect.ValueOf(v).Convert(t).Interface(), nil
+	}
+	return nil, fmt.Errorf("cannot use %v (type %s) as %s", v, vt, t)
+}
+
+// representable reports whether the numeric value v can be converted to the
+// numeric type t without changing the value, like an untyped constant.
+// e.g. 300 is not representable by uint8, and 3.9 is not by int.
+// The floating-point values may be rounded.
+// The complex values are not converted to the other numeric types, and vice
+// versa, as reflect cannot convert them.
+func representable(v reflect.Value, t reflect.Type) bool {
+	z := reflect.New(t).Elem()
+	if isComplex(v.Type()) || isComplex(t) {
+		return isComplex(v.Type()) && isComplex(t) && !z.OverflowComplex(v.Complex())
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x := v.Int()
+		switch {
+		case isInt(t):
+			return !z.OverflowInt(x)
+		case isUint(t):
+			return x >= 0 && !z.OverflowUint(uint64(x))
+		}
+		return true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x := v.Uint()
+		switch {
+		case isInt(t):
+			return x <= math.MaxInt64 && !z.OverflowInt(int64(x))
+		case isUint(t):
+			return !z.OverflowUint(x)
+		}
+		return true
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		switch {
+		case isInt(t), isUint(t):
+			if f != math.Trunc(f) || math.IsInf(f, 0) {
+				// including NaN
+				return false
+			}
+			if isInt(t) {
+				return f >= math.MinInt64 && f < math.MaxInt64 && !z.OverflowInt(int64(f))
+			}
+			return f >= 0 && f < math.MaxUint64 && !z.OverflowUint(uint64(f))
+		}
+		return !z.OverflowFloat(f)
+	}
+	return false
+}
+
+func isInt(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isComplex(t reflect.Type) bool {
+	return t.Kind() == reflect.Complex64 || t.Kind() == reflect.Complex128
+}
+
+func isUint(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func isNumeric(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
+
+// handleResultError calls the handler set by aspect.SetResultErrorHandler(),
+// or panics with err.
+func handleResultError(err *aspect.ResultError) {
+	if h := registry.ResultErrorHandler(); h != nil {
+		h(err)
+		return
+	}
+	panic(err)
+}
+
+// CheckResults is used by the woven code for checking the results res of the
+// advice of the aspect asp.
+// If res does not match the results of the joinpoint, the handler set by
+// aspect.SetResultErrorHandler() is called, and the zero values are returned.
+func CheckResults(ctx *ContextImpl, asp string, res []interface{}) []interface{} {
+	types := ctx.resultTypes()
+	reason := ""
+	if len(res) != len(types) {
+		reason = fmt.Sprintf("%d result(s)", len(res))
+	} else {
+		for i, t := range types {
+			if !isResultOf(res[i], t) {
+				reason = fmt.Sprintf("result %d is %T", i, res[i])
+				break
+			}
+		}
+	}
+	if reason == "" {
+		return res
+	}
+	handleResultError(&aspect.ResultError{
+		Aspect:   asp,
+		Callee:   ctx.XCallSite.Callee,
+		Expected: tupleString(types),
+		Results:  res,
+		Reason:   reason,
+	})
+	return ctx.ZeroResults()
+}
+
+// isResultOf reports whether v can be type-asserted to t by the woven code.
+// nil is accepted for the types that can be nil, as the woven code uses the
+// zero value for it.
+func isResultOf(v interface{}, t reflect.Type) bool {
+	if v == nil {
+		return isNillable(t)
+	}
+	if t.Kind() == reflect.Interface {
+		return reflect.TypeOf(v).Implements(t)
+	}
+	return reflect.TypeOf(v) == t
+}
+
+func isNillable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func,
+		reflect.Interface, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+// tupleString returns the string like "(int, error)".
+func tupleString(types []reflect.Type) string {
+	var s []string
+	for _, t := range types {
+		s = append(s, t.String())
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
@@ -2,6 +2,30 @@
 // Do NOT access rt from an aspect file.
 package rt
 
+import (
+	"context"
+	"fmt"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/aspect/internal/registry"
+)
+
+// CallSite is used by the woven code for declaring call sites.
+type CallSite = aspect.CallSite
+
+// RegisterCallSite is used by the woven code for declaring call sites.
+// The call site is registered as the join point id woven with aspects,
+// so that it can be enumerated by aspect.JoinPoints().
+func RegisterCallSite(id string, site *CallSite, aspects ...string) *CallSite {
+	registry.RegisterJoinPoint(registry.JoinPoint{
+		ID:       id,
+		Callee:   site.Callee,
+		Position: site.Position,
+		Aspects:  aspects,
+	})
+	return site
+}
+
 // ContextImpl implements aspect.Context
 type ContextImpl struct {
 	// XArgs should NOT be accessed manually.
@@ -12,6 +36,22 @@ type ContextImpl struct {
 
 	// XReceiver should NOT be accessed manually.
 	XReceiver interface{}
+
+	// XCallSite should NOT be accessed manually.
+	XCallSite *CallSite
+
+	// XFuncOn should NOT be accessed manually.
+	// XFuncOn is set for methods.
+	XFuncOn func(recv interface{}, args []interface{}) []interface{}
+
+	// XResultTypes should NOT be accessed manually.
+	// XResultTypes are the nil pointers to the result types,
+	// e.g. []interface{}{(*int)(nil), (*error)(nil)}.
+	XResultTypes []interface{}
+
+	// XGoContext should NOT be accessed manually.
+	// XGoContext is set if the first argument is context.Context.
+	XGoContext bool
 }
 
 // Args should NOT be called manually.
@@ -24,7 +64,51 @@ func (ctx *ContextImpl) Call(args []interface{}) []interface{} {
 	return ctx.XFunc(args)
 }
 
+// CallOn should NOT be called manually.
+func (ctx *ContextImpl) CallOn(recv interface{}, args []interface{}) []interface{} {
+	if ctx.XFuncOn == nil {
+		panic(fmt.Errorf("%s is not a method", ctx.XCallSite.Callee))
+	}
+	return ctx.XFuncOn(recv, args)
+}
+
+// NewReceiverTypeError is used by the woven code for CallOn.
+func NewReceiverTypeError(site *CallSite, expected string, actual interface{}) error {
+	return &aspect.ReceiverTypeError{
+		Callee:   site.Callee,
+		Expected: expected,
+		Actual:   actual,
+	}
+}
+
 // Receiver should NOT be called manually.
 func (ctx *ContextImpl) Receiver() interface{} {
 	return ctx.XReceiver
 }
+
+// CallSite should NOT be called manually.
+func (ctx *ContextImpl) CallSite() *CallSite {
+	return ctx.XCallSite
+}
+
+// GoContext should NOT be called manually.
+func (ctx *ContextImpl) GoContext() (context.Context, bool) {
+	if !ctx.XGoContext || len(ctx.XArgs) == 0 {
+		return nil, false
+	}
+	c, _ := ctx.XArgs[0].(context.Context)
+	return c, true
+}
+
+// WithGoContext should NOT be called manually.
+func (ctx *ContextImpl) WithGoContext(args []interface{}, c context.Context) []interface{} {
+	if !ctx.XGoContext {
+		panic(fmt.Errorf("%s does not take context.Context", ctx.XCallSite.Callee))
+	}
+	if len(args) == 0 {
+		panic(fmt.Errorf("%s takes context.Context, but args is empty", ctx.XCallSite.Callee))
+	}
+	res := append([]interface{}(nil), args...)
+	res[0] = c
+	return res
+}
@@ -1,9 +1,14 @@
 package rt
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"runtime"
 	"runtime/debug"
 	"testing"
+	"time"
+	"unsafe"
 
 	asp "github.com/AkihiroSuda/aspectgo/aspect"
 )
@@ -40,3 +45,409 @@ func TestContextImpl(t *testing.T) {
 				return _ag_res
 			}})
 }
+
+func TestContextImplCallSite(t *testing.T) {
+	site := &CallSite{Callee: "sayHello", Position: "rt/rt_test.go:42:2"}
+	ctx := &ContextImpl{
+		XArgs: []interface{}{"world"},
+		XFunc: func(_ag_args []interface{}) []interface{} {
+			return []interface{}{}
+		},
+		XCallSite: site}
+	if ctx.CallSite() != site {
+		t.Fatalf("expected %s, got %s", site, ctx.CallSite())
+	}
+}
+
+type policyAspect struct {
+	dummyAspect
+	policy asp.Instantiation
+}
+
+func (a *policyAspect) Instantiation() asp.Instantiation {
+	return a.policy
+}
+
+func newPolicyHolder(policy asp.Instantiation) *Holder {
+	return NewHolder("policyAspect", func() asp.Aspect {
+		return &policyAspect{policy: policy}
+	})
+}
+
+func TestHolder(t *testing.T) {
+	type recv struct{ x int }
+	r1, r2 := &recv{1}, &recv{2}
+	testCases := []struct {
+		policy asp.Instantiation
+		recvs  []interface{}
+		// expected is the number of the distinct instances returned
+		expected int
+	}{
+		{asp.Singleton, []interface{}{nil, r1, r2}, 1},
+		{asp.PerGoroutine, []interface{}{nil, r1, r2}, 1},
+		{asp.PerThis, []interface{}{nil, r1, r1, r2, *r1, *r1}, 5},
+		{asp.PerThis, []interface{}{nil, (*recv)(nil), []int{1}, []int{1}}, 3},
+		{asp.PerCall, []interface{}{nil, r1, r1}, 3},
+	}
+	for _, tc := range testCases {
+		h := newPolicyHolder(tc.policy)
+		instances := make(map[asp.Aspect]bool)
+		for _, r := range tc.recvs {
+			instances[h.Instance(r)] = true
+		}
+		if len(instances) != tc.expected {
+			t.Fatalf("%s: expected %d instances, got %d", tc.policy, tc.expected, len(instances))
+		}
+		if h.Instantiation() != tc.policy {
+			t.Fatalf("expected %s, got %s", tc.policy, h.Instantiation())
+		}
+	}
+}
+
+func TestHolderPerGoroutine(t *testing.T) {
+	h := newPolicyHolder(asp.PerGoroutine)
+	a := h.Instance(nil)
+	ch := make(chan asp.Aspect)
+	go func() {
+		ch <- h.Instance(nil)
+	}()
+	if b := <-ch; a == b {
+		t.Fatal("expected different instances for different goroutines")
+	}
+	if h.Instance(nil) != a {
+		t.Fatal("expected the same instance for the same goroutine")
+	}
+}
+
+func TestHolderPerThisRelease(t *testing.T) {
+	h := newPolicyHolder(asp.PerThis)
+	h.Instance(nil)
+	h.Instance(&struct{ x [64]byte }{})
+	for i := 0; i < 100; i++ {
+		runtime.GC()
+		n := 0
+		h.instances.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		if n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected the instance to be released after the receiver is collected")
+}
+
+func TestHolderFirstInstance(t *testing.T) {
+	n := 0
+	h := NewHolder("countedAspect", func() asp.Aspect {
+		n++
+		return &policyAspect{policy: asp.PerCall}
+	})
+	if h.Instantiation() != asp.PerCall || n != 1 {
+		t.Fatalf("expected 1 instance, got %d", n)
+	}
+	h.Instance(nil)
+	if n != 1 {
+		t.Fatalf("expected the first instance to be used, got %d instances", n)
+	}
+	h.Instance(nil)
+	if n != 2 {
+		t.Fatalf("expected 2 instances, got %d", n)
+	}
+}
+
+func TestHolderDefault(t *testing.T) {
+	h := NewHolder("dummyAspect", func() asp.Aspect { return &dummyAspect{} })
+	if h.Instantiation() != asp.Singleton || h.Instance(nil) != h.Instance(nil) {
+		t.Fatal("expected a singleton")
+	}
+}
+
+func TestHolderEnabled(t *testing.T) {
+	h := NewHolder("switchedAspect", func() asp.Aspect { return &dummyAspect{} })
+	if !h.Enabled() {
+		t.Fatal("expected enabled by default")
+	}
+	if err := asp.Disable("switchedAspect"); err != nil {
+		t.Fatal(err)
+	}
+	if h.Enabled() {
+		t.Fatal("expected disabled")
+	}
+	if err := asp.SetSampling("switchedAspect", 0.5); err != nil {
+		t.Fatal(err)
+	}
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		if h.Enabled() {
+			enabled++
+		}
+	}
+	if enabled == 0 || enabled == 1000 {
+		t.Fatalf("unexpected sampling: %d/1000", enabled)
+	}
+	if err := asp.SetSampling("switchedAspect", 2); err == nil {
+		t.Fatal("expected an error for an invalid rate")
+	}
+	if err := asp.Enable("nonexistentAspect"); err == nil {
+		t.Fatal("expected an error for an unregistered aspect")
+	}
+	found := false
+	for _, name := range asp.Registered() {
+		found = found || name == "switchedAspect"
+	}
+	if !found {
+		t.Fatalf("switchedAspect not registered: %v", asp.Registered())
+	}
+}
+
+func TestRegisterCallSite(t *testing.T) {
+	site := &CallSite{Callee: "sayHello", Position: "rt/rt_test.go:42:2"}
+	if RegisterCallSite("0123", site, "dummyAspect") != site {
+		t.Fatal("unexpected call site")
+	}
+	for _, jp := range asp.JoinPoints() {
+		if jp.ID == "0123" {
+			expected := "rt/rt_test.go:42:2: sayHello (dummyAspect) [0123]"
+			if jp.String() != expected {
+				t.Fatalf("expected %q, got %q", expected, jp.String())
+			}
+			return
+		}
+	}
+	t.Fatalf("join point not found: %v", asp.JoinPoints())
+}
+
+func TestContextImplGoContext(t *testing.T) {
+	type key struct{}
+	c := context.WithValue(context.Background(), key{}, "original")
+	ctx := &ContextImpl{
+		XArgs: []interface{}{c, "world"},
+		XFunc: func(_ag_args []interface{}) []interface{} {
+			_ag_arg0 := _ag_args[0].(context.Context)
+			return []interface{}{_ag_arg0.Value(key{})}
+		},
+		XCallSite:  &CallSite{Callee: "fetch"},
+		XGoContext: true}
+	if got, ok := ctx.GoContext(); !ok || got != c {
+		t.Fatalf("unexpected context: %v, %v", got, ok)
+	}
+	c2 := context.WithValue(c, key{}, "replaced")
+	args := ctx.WithGoContext(ctx.Args(), c2)
+	if ctx.Args()[0] != c || args[1] != "world" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	if res := ctx.Call(args); res[0] != "replaced" {
+		t.Fatalf("unexpected result: %v", res)
+	}
+
+	ctx.XArgs = []interface{}{}
+	if _, ok := ctx.GoContext(); ok {
+		t.Fatal("expected no context for empty args")
+	}
+	expectPanic(t, "fetch takes context.Context, but args is empty", func() {
+		ctx.WithGoContext(ctx.Args(), c2)
+	})
+
+	ctx.XGoContext = false
+	if _, ok := ctx.GoContext(); ok {
+		t.Fatal("expected no context")
+	}
+	expectPanic(t, "fetch does not take context.Context", func() {
+		ctx.WithGoContext([]interface{}{c, "world"}, c2)
+	})
+}
+
+func expectPanic(t *testing.T, expected string, f func()) {
+	t.Helper()
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || err.Error() != expected {
+			t.Fatalf("expected panic %q, got %v", expected, err)
+		}
+	}()
+	f()
+}
+
+func TestContextImplCallOn(t *testing.T) {
+	type recv struct{ name string }
+	site := &CallSite{Callee: "(*recv).hello"}
+	ctx := &ContextImpl{
+		XCallSite: site,
+		XFuncOn: func(_ag_newrecv interface{}, _ag_args []interface{}) []interface{} {
+			_ag_recv, _ag_ok := _ag_newrecv.(*recv)
+			if !_ag_ok {
+				panic(NewReceiverTypeError(site, "*recv", _ag_newrecv))
+			}
+			return []interface{}{_ag_recv.name}
+		}}
+	if res := ctx.CallOn(&recv{"mock"}, nil); res[0] != "mock" {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	defer func() {
+		err, ok := recover().(*asp.ReceiverTypeError)
+		if !ok {
+			t.Fatalf("unexpected panic: %v", err)
+		}
+		expected := "cannot call (*recv).hello on a receiver of type string: expected *recv"
+		if err.Error() != expected {
+			t.Fatalf("expected %q, got %q", expected, err.Error())
+		}
+	}()
+	ctx.CallOn("invalid", nil)
+}
+
+func TestContextImplResults(t *testing.T) {
+	type myInt int64
+	ctx := &ContextImpl{
+		XCallSite:    &CallSite{Callee: "foo"},
+		XResultTypes: []interface{}{(*myInt)(nil), (*string)(nil), (*[]int)(nil), (*error)(nil)},
+	}
+	if res := ctx.ZeroResults(); fmt.Sprintf("%#v", res) !=
+		`[]interface {}{0, "", []int(nil), interface {}(nil)}` {
+		t.Fatalf("unexpected zero results: %#v", res)
+	}
+	err := fmt.Errorf("injected")
+	res := ctx.Results(42, nil, []int{1}, err)
+	if v, ok := res[0].(myInt); !ok || v != 42 || res[1] != "" || res[3] != err {
+		t.Fatalf("unexpected results: %#v", res)
+	}
+	res = ctx.ReturnError(err)
+	if v, ok := res[0].(myInt); !ok || v != 0 || res[3] != err {
+		t.Fatalf("unexpected results: %#v", res)
+	}
+
+	for _, f := range []func(){
+		func() { ctx.Results(42) },
+		func() { ctx.Results("42", nil, nil, nil) },
+		func() { (&ContextImpl{XCallSite: ctx.XCallSite}).ReturnError(err) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected panic")
+				}
+			}()
+			f()
+		}()
+	}
+}
+
+func TestContextImplResultsNumeric(t *testing.T) {
+	testCases := []struct {
+		value    interface{}
+		typ      interface{}
+		expected interface{}
+	}{
+		{42, (*uint8)(nil), uint8(42)},
+		{-1, (*int8)(nil), int8(-1)},
+		{3.0, (*int)(nil), 3},
+		{uint64(42), (*int64)(nil), int64(42)},
+		{42, (*float32)(nil), float32(42)},
+		{0.1, (*float32)(nil), float32(0.1)},
+		{complex(2, 1), (*complex64)(nil), complex64(complex(2, 1))},
+		{300, (*uint8)(nil), nil},
+		{-1, (*uint)(nil), nil},
+		{128, (*int8)(nil), nil},
+		{3.9, (*int)(nil), nil},
+		{math.NaN(), (*int)(nil), nil},
+		{math.Inf(1), (*uint64)(nil), nil},
+		{1e100, (*int64)(nil), nil},
+		{1e100, (*float32)(nil), nil},
+		{uint64(math.MaxUint64), (*int64)(nil), nil},
+		{complex(2, 0), (*int)(nil), nil},
+		{2, (*complex64)(nil), nil},
+	}
+	for _, tc := range testCases {
+		ctx := &ContextImpl{
+			XCallSite:    &CallSite{Callee: "foo"},
+			XResultTypes: []interface{}{tc.typ},
+		}
+		var res []interface{}
+		func() {
+			defer func() {
+				if r := recover(); r != nil && tc.expected != nil {
+					t.Fatalf("%v to %T: unexpected panic: %v", tc.value, tc.typ, r)
+				}
+			}()
+			res = ctx.Results(tc.value)
+		}()
+		if tc.expected == nil {
+			if res != nil {
+				t.Fatalf("%v to %T: expected panic, got %#v", tc.value, tc.typ, res)
+			}
+			continue
+		}
+		if res[0] != tc.expected {
+			t.Fatalf("%v to %T: expected %#v, got %#v", tc.value, tc.typ, tc.expected, res[0])
+		}
+	}
+}
+
+func TestCheckResults(t *testing.T) {
+	ctx := &ContextImpl{
+		XCallSite:    &CallSite{Callee: "foo"},
+		XResultTypes: []interface{}{(*int)(nil), (*error)(nil)},
+	}
+	res := []interface{}{42, nil}
+	if got := CheckResults(ctx, "dummyAspect", res); fmt.Sprint(got) != fmt.Sprint(res) {
+		t.Fatalf("unexpected results: %#v", got)
+	}
+	for _, res := range [][]interface{}{
+		{42},
+		{int64(42), nil},
+		{nil, nil},
+		{42, "error"},
+	} {
+		func() {
+			defer func() {
+				err, ok := recover().(*asp.ResultError)
+				if !ok {
+					t.Fatalf("expected *ResultError for %#v", res)
+				}
+				if err.Aspect != "dummyAspect" || err.Callee != "foo" || err.Expected != "(int, error)" {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}()
+			CheckResults(ctx, "dummyAspect", res)
+		}()
+	}
+
+	var handled error
+	asp.SetResultErrorHandler(func(err *asp.ResultError) { handled = err })
+	defer asp.SetResultErrorHandler(nil)
+	if got := CheckResults(ctx, "dummyAspect", []interface{}{"42"}); handled == nil ||
+		fmt.Sprintf("%#v", got) != `[]interface {}{0, interface {}(nil)}` {
+		t.Fatalf("unexpected results: %#v (%v)", got, handled)
+	}
+}
+
+func TestCheckResultsNil(t *testing.T) {
+	type T struct{}
+	ctx := &ContextImpl{
+		XCallSite:    &CallSite{Callee: "find"},
+		XResultTypes: []interface{}{(**T)(nil), (*error)(nil)},
+	}
+	errSkip := fmt.Errorf("skip")
+	for _, res := range [][]interface{}{
+		{nil, errSkip},
+		{&T{}, nil},
+		{(*T)(nil), errSkip},
+	} {
+		if got := CheckResults(ctx, "dummyAspect", res); fmt.Sprint(got) != fmt.Sprint(res) {
+			t.Fatalf("unexpected results: %#v", got)
+		}
+	}
+	for _, typ := range []interface{}{(*[]int)(nil), (*map[int]int)(nil),
+		(*chan int)(nil), (*func())(nil), (*unsafe.Pointer)(nil)} {
+		ctx := &ContextImpl{
+			XCallSite:    &CallSite{Callee: "foo"},
+			XResultTypes: []interface{}{typ},
+		}
+		res := []interface{}{nil}
+		if got := CheckResults(ctx, "dummyAspect", res); len(got) != 1 || got[0] != nil {
+			t.Fatalf("unexpected results for %T: %#v", typ, got)
+		}
+	}
+}
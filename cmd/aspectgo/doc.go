@@ -2,12 +2,53 @@
 AspectGo weaves aspects to Go programs.
 
 Usage:
-	aspectgo flags path
-The flags are:
+	aspectgo [weave] flags path
+	aspectgo build -a aspectfile [-w wovengopath] [-strict] [-weave-deps pkgs] [build flags] [packages]
+	aspectgo run -a aspectfile [-w wovengopath] [-strict] [-weave-deps pkgs] [build flags] package [arguments...]
+	aspectgo test -a aspectfile [-w wovengopath] [-strict] [-weave-deps pkgs] [build/test flags] [packages]
+	aspectgo plan -a aspectfile [-tests] [-json] [-strict] [-weave-deps pkgs] [packages]
+	aspectgo clean [-w wovengopath]
+
+The flags for weave are:
 	-t target
 		Specify the target package name.
 	-w wovengopath
 		Specify the output GOPATH.
                 The default value is /tmp/wovengopath.
+	-tests
+		Weave _test.go files as well.
+	-dry-run
+		Print the join points to be woven, without weaving. Same as plan.
+	-json
+		Print the join points in JSON. Only for -dry-run.
+	-strict
+		Fail if the pointcut of an aspect matches no join point.
+		Without -strict, such an aspect is reported as a warning.
+		Also available for build, run, test and plan.
+	-weave-deps pkgs
+		Weave the comma-separated dependency packages as well, so that
+		the calls made inside them can be advised.
+		e.g. -weave-deps=net/http,github.com/lib/pq
+		A vendored package can be specified without the vendor directory.
+		GOROOT packages are woven via .aspectgo-overlay.json in the
+		woven GOPATH, which needs to be passed to `go build -overlay`.
+		The build, run and test subcommands pass it automatically.
+		The packages imported by the aspect file cannot be woven.
+		Also available for build, run, test and plan.
+
+The build, run and test subcommands weave the aspect file to the packages,
+and then execute `go build`, `go run` and `go test` for the woven packages.
+For test, the aspect file is woven to _test.go files as well.
+The flags other than -a, -w, -debug, -strict and -weave-deps are passed
+through to the go tool.
+Unless -w is specified, a temporary woven GOPATH is used.
+
+The plan subcommand prints each join point to be woven, with the position
+of the call site, the callee, and the aspect to be applied.
+Nothing is written to the woven GOPATH.
+
+The clean subcommand removes the woven GOPATH.
+An existing woven GOPATH is reused on weaving, so that a package can be woven
+into the woven GOPATH of another package. Use clean for starting from scratch.
 */
 package main
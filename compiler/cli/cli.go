@@ -6,22 +6,63 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/AkihiroSuda/aspectgo/compiler"
+	"github.com/AkihiroSuda/aspectgo/compiler/gopath"
 	"github.com/AkihiroSuda/aspectgo/compiler/util"
 )
 
+// defaultWovenGOPATH is the default value for the woven GOPATH of `aspectgo weave`.
+const defaultWovenGOPATH = "/tmp/wovengopath"
+
 // Main is the CLI for AspectGo.
+//
+// Subcommands:
+//
+//	weave: weave the aspect file to the target package
+//	build, run, test: weave the aspect file and execute the go tool
+//	plan: print the join points to be woven, without weaving
+//	clean: remove the woven GOPATH
+//
+// If no subcommand is specified, Main behaves as weave.
 func Main(args []string) int {
+	if len(args) >= 2 {
+		subArgs := append([]string{args[0] + " " + args[1]}, args[2:]...)
+		switch args[1] {
+		case "weave":
+			return mainWeave(subArgs)
+		case "build", "run", "test":
+			return mainGo(subArgs, args[1])
+		case "plan":
+			return mainPlan(subArgs)
+		case "clean":
+			return mainClean(subArgs)
+		}
+	}
+	return mainWeave(args)
+}
+
+func mainWeave(args []string) int {
 	var (
-		debug  bool
-		weave  string
-		target string
+		debug      bool
+		weave      string
+		target     string
+		tests      bool
+		dryRun     bool
+		jsonOutput bool
+		strict     bool
+		weaveDeps  string
 	)
 	f := flag.NewFlagSet(args[0], flag.ExitOnError)
 	f.BoolVar(&debug, "debug", false, "enable debug print")
-	f.StringVar(&weave, "w", "/tmp/wovengopath", "woven gopath")
+	f.StringVar(&weave, "w", defaultWovenGOPATH, "woven gopath")
 	f.StringVar(&target, "t", "", "target package name")
+	f.BoolVar(&tests, "tests", false, "weave _test.go files as well")
+	f.BoolVar(&dryRun, "dry-run", false, "print the join points to be woven, without weaving")
+	f.BoolVar(&jsonOutput, "json", false, "print in JSON (for -dry-run)")
+	f.BoolVar(&strict, "strict", false, "fail if a pointcut matches no join point")
+	f.StringVar(&weaveDeps, "weave-deps", "", weaveDepsUsage)
 	f.Parse(args[1:])
 
 	if target == "" {
@@ -37,17 +78,19 @@ func Main(args []string) int {
 		return 1
 	}
 
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	util.DebugMode = debug
-	if util.DebugMode {
-		log.Printf("running in debug mode")
-	}
+	setDebugMode(debug)
 
 	aspectFile := f.Args()[0]
 	comp := compiler.Compiler{
 		WovenGOPATH:     weave,
 		Target:          target,
 		AspectFilenames: []string{aspectFile},
+		Tests:           tests,
+		Strict:          strict,
+		WeaveDeps:       splitList(weaveDeps),
+	}
+	if dryRun {
+		return plan(&comp, jsonOutput)
 	}
 	if err := comp.Do(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -55,3 +98,38 @@ func Main(args []string) int {
 	}
 	return 0
 }
+
+func mainClean(args []string) int {
+	var weave string
+	f := flag.NewFlagSet(args[0], flag.ExitOnError)
+	f.StringVar(&weave, "w", defaultWovenGOPATH, "woven gopath")
+	f.Parse(args[1:])
+
+	if err := gopath.Clean(weave); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// weaveDepsUsage is the usage for -weave-deps.
+const weaveDepsUsage = "comma-separated dependency packages to be woven as well (e.g. net/http,github.com/lib/pq)"
+
+// splitList splits the comma-separated list s.
+func splitList(s string) []string {
+	var l []string
+	for _, x := range strings.Split(s, ",") {
+		if x = strings.TrimSpace(x); x != "" {
+			l = append(l, x)
+		}
+	}
+	return l
+}
+
+func setDebugMode(debug bool) {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	util.DebugMode = debug
+	if util.DebugMode {
+		log.Printf("running in debug mode")
+	}
+}
@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/AkihiroSuda/aspectgo/compiler"
+	"github.com/AkihiroSuda/aspectgo/compiler/gopath"
+)
+
+// mainGo implements `aspectgo build`, `aspectgo run` and `aspectgo test`.
+// It weaves the aspect file to the packages, and then executes
+// `go build`, `go run` or `go test` for the woven packages.
+// For `aspectgo test`, the aspect file is woven to _test.go files as well.
+//
+// Usage:
+//
+//	aspectgo build -a aspectfile [-w wovengopath] [-strict] [-weave-deps pkgs] [build flags] [packages]
+//	aspectgo run -a aspectfile [-w wovengopath] [-strict] [-weave-deps pkgs] [build flags] package [arguments...]
+//	aspectgo test -a aspectfile [-w wovengopath] [-strict] [-weave-deps pkgs] [build/test flags] [packages]
+//
+// Unless -w is specified, a temporary woven GOPATH is created and removed
+// after the execution.
+func mainGo(args []string, goSubcmd string) int {
+	var (
+		debug      bool
+		weave      string
+		aspectFile string
+		strict     bool
+		weaveDeps  string
+	)
+	f := flag.NewFlagSet(args[0], flag.ExitOnError)
+	f.BoolVar(&debug, "debug", false, "enable debug print")
+	f.StringVar(&weave, "w", "", "woven gopath (default: temporary directory)")
+	f.StringVar(&aspectFile, "a", "", "aspect file")
+	f.BoolVar(&strict, "strict", false, "fail if a pointcut matches no join point")
+	f.StringVar(&weaveDeps, "weave-deps", "", weaveDepsUsage)
+	// for `go run`, the arguments after the package are for the program
+	interspersed := goSubcmd != "run"
+	own, goFlags, positional := splitArgs(f, args[1:], interspersed)
+	f.Parse(own)
+
+	if aspectFile == "" {
+		fmt.Fprintf(os.Stderr, "No aspect file specified\n")
+		return 1
+	}
+	oldGOPATH := os.Getenv("GOPATH")
+	if oldGOPATH == "" {
+		fmt.Fprintf(os.Stderr, "GOPATH not set\n")
+		return 1
+	}
+	pkgs, progArgs := positional, []string(nil)
+	if goSubcmd == "run" {
+		if len(positional) == 0 {
+			fmt.Fprintf(os.Stderr, "No package specified\n")
+			return 1
+		}
+		pkgs, progArgs = positional[:1], positional[1:]
+	}
+	if len(pkgs) == 0 {
+		pkgs = []string{"."}
+	}
+	// local packages need to be converted to import paths,
+	// as the go tool is executed with the woven GOPATH.
+	var targets []string
+	for _, pkg := range pkgs {
+		target, err := compiler.ImportPath(oldGOPATH, pkg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		targets = append(targets, target)
+	}
+
+	setDebugMode(debug)
+
+	if weave == "" {
+		d, err := ioutil.TempDir("", "aspectgo")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer os.RemoveAll(d)
+		weave = d
+	}
+	comp := compiler.Compiler{
+		WovenGOPATH:     weave,
+		Targets:         targets,
+		AspectFilenames: []string{aspectFile},
+		Tests:           goSubcmd == "test",
+		Strict:          strict,
+		WeaveDeps:       splitList(weaveDeps),
+	}
+	if err := comp.Do(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	goArgs := []string{goSubcmd}
+	if overlay := gopath.Overlay(weave); overlay != "" {
+		// for the woven GOROOT packages
+		goArgs = append(goArgs, "-overlay="+overlay)
+	}
+	goArgs = append(goArgs, goFlags...)
+	goArgs = append(goArgs, targets...)
+	goArgs = append(goArgs, progArgs...)
+	log.Printf("Running go %v (GOPATH=%s)", goArgs, weave)
+	if err := goCommand(weave, goArgs...).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
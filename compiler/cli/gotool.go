@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// goFlagsWithValue is the set of the go tool flags that take a value.
+// Unknown flags are passed through to the go tool as boolean flags.
+var goFlagsWithValue = map[string]bool{
+	// build flags
+	"C":             true,
+	"asmflags":      true,
+	"buildmode":     true,
+	"compiler":      true,
+	"exec":          true,
+	"gccgoflags":    true,
+	"gcflags":       true,
+	"installsuffix": true,
+	"ldflags":       true,
+	"o":             true,
+	"p":             true,
+	"pkgdir":        true,
+	"tags":          true,
+	"toolexec":      true,
+	// test flags
+	"bench":                true,
+	"benchtime":            true,
+	"blockprofile":         true,
+	"blockprofilerate":     true,
+	"count":                true,
+	"covermode":            true,
+	"coverpkg":             true,
+	"coverprofile":         true,
+	"cpu":                  true,
+	"cpuprofile":           true,
+	"list":                 true,
+	"memprofile":           true,
+	"memprofilerate":       true,
+	"mutexprofile":         true,
+	"mutexprofilefraction": true,
+	"outputdir":            true,
+	"parallel":             true,
+	"run":                  true,
+	"skip":                 true,
+	"timeout":              true,
+	"trace":                true,
+}
+
+// splitArgs splits args into the flags defined in f, the flags to be
+// passed through to the go tool, and the other (positional) arguments.
+// Arguments after "--" are treated as positional arguments.
+// If interspersed is false, the arguments after the first positional
+// argument are treated as positional arguments as well.
+func splitArgs(f *flag.FlagSet, args []string, interspersed bool) ([]string, []string, []string) {
+	var own, goFlags, rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			if !interspersed {
+				rest = append(rest, args[i:]...)
+				break
+			}
+			rest = append(rest, arg)
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		hasValue := false
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, hasValue = name[:eq], true
+		}
+		if fl := f.Lookup(name); fl != nil {
+			own = append(own, arg)
+			if !hasValue && !isBoolFlag(fl) && i+1 < len(args) {
+				i++
+				own = append(own, args[i])
+			}
+			continue
+		}
+		goFlags = append(goFlags, arg)
+		if !hasValue && goFlagsWithValue[name] && i+1 < len(args) {
+			i++
+			goFlags = append(goFlags, args[i])
+		}
+	}
+	return own, goFlags, rest
+}
+
+func isBoolFlag(fl *flag.Flag) bool {
+	b, ok := fl.Value.(interface {
+		IsBoolFlag() bool
+	})
+	return ok && b.IsBoolFlag()
+}
+
+// goCommand returns the command for executing the go tool with gopath as GOPATH.
+func goCommand(gopath string, args ...string) *exec.Cmd {
+	cmd := exec.Command("go", args...)
+	// the later one takes precedence
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
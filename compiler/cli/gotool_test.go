@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	testCases := []struct {
+		args         []string
+		interspersed bool
+		own          []string
+		goFlags      []string
+		rest         []string
+		// parseErr is true if the own flags cannot be parsed
+		parseErr bool
+	}{
+		{
+			args:         []string{"-a", "x_aspect.go", "-w", "/tmp/w", "./foo"},
+			interspersed: true,
+			own:          []string{"-a", "x_aspect.go", "-w", "/tmp/w"},
+			rest:         []string{"./foo"},
+		},
+		{
+			args:         []string{"-a=x_aspect.go", "-debug", "-v", "-run", "Ext", "./foo", "-count", "1"},
+			interspersed: true,
+			own:          []string{"-a=x_aspect.go", "-debug"},
+			goFlags:      []string{"-v", "-run", "Ext", "-count", "1"},
+			rest:         []string{"./foo"},
+		},
+		{
+			// unknown flags are boolean
+			args:         []string{"-race", "-unknown", "./foo"},
+			interspersed: true,
+			goFlags:      []string{"-race", "-unknown"},
+			rest:         []string{"./foo"},
+		},
+		{
+			args:         []string{"--ldflags=-s -w", "-o", "bin", "-debug=false", "./foo"},
+			interspersed: true,
+			own:          []string{"-debug=false"},
+			goFlags:      []string{"--ldflags=-s -w", "-o", "bin"},
+			rest:         []string{"./foo"},
+		},
+		{
+			// for `go run`, the arguments after the package are for the program
+			args:         []string{"-a", "x_aspect.go", "-gcflags", "-N", "./foo", "-v", "-a", "x"},
+			interspersed: false,
+			own:          []string{"-a", "x_aspect.go"},
+			goFlags:      []string{"-gcflags", "-N"},
+			rest:         []string{"./foo", "-v", "-a", "x"},
+		},
+		{
+			args:         []string{"-strict", "--", "-foo", "./bar"},
+			interspersed: true,
+			own:          []string{"-strict"},
+			rest:         []string{"-foo", "./bar"},
+		},
+		{
+			// the missing value
+			args:         []string{"-v", "-a"},
+			interspersed: true,
+			own:          []string{"-a"},
+			goFlags:      []string{"-v"},
+			parseErr:     true,
+		},
+		{
+			args:         []string{"-", "-tags", "foo"},
+			interspersed: true,
+			goFlags:      []string{"-tags", "foo"},
+			rest:         []string{"-"},
+		},
+	}
+	for _, tc := range testCases {
+		f := flag.NewFlagSet("test", flag.ContinueOnError)
+		f.String("a", "", "")
+		f.String("w", "", "")
+		f.Bool("debug", false, "")
+		f.Bool("strict", false, "")
+		own, goFlags, rest := splitArgs(f, tc.args, tc.interspersed)
+		if !reflect.DeepEqual(own, tc.own) || !reflect.DeepEqual(goFlags, tc.goFlags) ||
+			!reflect.DeepEqual(rest, tc.rest) {
+			t.Fatalf("%q: unexpected %q %q %q", tc.args, own, goFlags, rest)
+		}
+		f.SetOutput(ioutil.Discard)
+		if err := f.Parse(own); (err != nil) != tc.parseErr {
+			t.Fatalf("%q: unexpected error: %v", tc.args, err)
+		}
+	}
+}
+
+func TestIsBoolFlag(t *testing.T) {
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.Bool("b", false, "")
+	f.String("s", "", "")
+	f.Int("i", 0, "")
+	for name, expected := range map[string]bool{"b": true, "s": false, "i": false} {
+		if got := isBoolFlag(f.Lookup(name)); got != expected {
+			t.Fatalf("%s: expected %v, got %v", name, expected, got)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AkihiroSuda/aspectgo/compiler"
+	"github.com/AkihiroSuda/aspectgo/compiler/weave"
+)
+
+// mainPlan implements `aspectgo plan`.
+// It prints the join points to be woven, without writing anything.
+//
+// Usage:
+//
+//	aspectgo plan -a aspectfile [-tests] [-json] [-strict] [-weave-deps pkgs] [packages]
+func mainPlan(args []string) int {
+	var (
+		debug      bool
+		aspectFile string
+		tests      bool
+		jsonOutput bool
+		strict     bool
+		weaveDeps  string
+	)
+	f := flag.NewFlagSet(args[0], flag.ExitOnError)
+	f.BoolVar(&debug, "debug", false, "enable debug print")
+	f.StringVar(&aspectFile, "a", "", "aspect file")
+	f.BoolVar(&tests, "tests", false, "scan _test.go files as well")
+	f.BoolVar(&jsonOutput, "json", false, "print in JSON")
+	f.BoolVar(&strict, "strict", false, "fail if a pointcut matches no join point")
+	f.StringVar(&weaveDeps, "weave-deps", "", weaveDepsUsage)
+	f.Parse(args[1:])
+
+	if aspectFile == "" {
+		fmt.Fprintf(os.Stderr, "No aspect file specified\n")
+		return 1
+	}
+	pkgs := f.Args()
+	if len(pkgs) == 0 {
+		pkgs = []string{"."}
+	}
+
+	setDebugMode(debug)
+
+	comp := compiler.Compiler{
+		Targets:         pkgs,
+		AspectFilenames: []string{aspectFile},
+		Tests:           tests,
+		Strict:          strict,
+		WeaveDeps:       splitList(weaveDeps),
+	}
+	return plan(&comp, jsonOutput)
+}
+
+func plan(comp *compiler.Compiler, jsonOutput bool) int {
+	jps, err := comp.Plan()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := printPlan(os.Stdout, jps, jsonOutput); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printPlan prints jps in a human-readable form like this:
+//
+//	/gopath/src/example.com/foo/main.go:12:2: example.com/foo.sayHello (ExampleAspect)
+//
+// If jsonOutput is true, jps is printed as a JSON array.
+func printPlan(w io.Writer, jps []weave.JoinPoint, jsonOutput bool) error {
+	if jsonOutput {
+		if jps == nil {
+			jps = []weave.JoinPoint{}
+		}
+		b, err := json.MarshalIndent(jps, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	for _, jp := range jps {
+		asp := jp.Aspect
+		if len(jp.Overridden) != 0 {
+			asp += ", overriding " + strings.Join(jp.Overridden, ", ")
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s (%s)\n", jp.Position, jp.Callee, asp); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d join point(s)\n", len(jps))
+	return err
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AkihiroSuda/aspectgo/compiler/weave"
+)
+
+var testJoinPoints = []weave.JoinPoint{
+	{
+		Position: token.Position{Filename: "/gopath/src/example.com/foo/main.go", Offset: 120, Line: 12, Column: 2},
+		Callee:   "example.com/foo.sayHello",
+		Aspect:   "ExampleAspect",
+		Pointcut: `example\.com/foo\.sayHello`,
+	},
+	{
+		Position:   token.Position{Filename: "/gopath/src/example.com/foo/main.go", Offset: 150, Line: 13, Column: 2},
+		Callee:     "fmt.Println",
+		Aspect:     "FmtPrintlnAspect",
+		Pointcut:   `fmt\.Println`,
+		Overridden: []string{"ExampleAspect"},
+	},
+}
+
+func TestPrintPlan(t *testing.T) {
+	var b bytes.Buffer
+	if err := printPlan(&b, testJoinPoints, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := "/gopath/src/example.com/foo/main.go:12:2: example.com/foo.sayHello (ExampleAspect)\n" +
+		"/gopath/src/example.com/foo/main.go:13:2: fmt.Println (FmtPrintlnAspect, overriding ExampleAspect)\n" +
+		"2 join point(s)\n"
+	if b.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, b.String())
+	}
+
+	b.Reset()
+	if err := printPlan(&b, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "0 join point(s)\n" {
+		t.Fatalf("unexpected output: %q", b.String())
+	}
+}
+
+func TestPrintPlanJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := printPlan(&b, testJoinPoints, true); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "Offset") {
+		t.Fatalf("unexpected offset in the output: %s", b.String())
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{
+			"Position": map[string]interface{}{
+				"Filename": "/gopath/src/example.com/foo/main.go", "Line": 12.0, "Column": 2.0},
+			"Callee":   "example.com/foo.sayHello",
+			"Aspect":   "ExampleAspect",
+			"Pointcut": `example\.com/foo\.sayHello`,
+		},
+		{
+			"Position": map[string]interface{}{
+				"Filename": "/gopath/src/example.com/foo/main.go", "Line": 13.0, "Column": 2.0},
+			"Callee":     "fmt.Println",
+			"Aspect":     "FmtPrintlnAspect",
+			"Pointcut":   `fmt\.Println`,
+			"Overridden": []interface{}{"ExampleAspect"},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	b.Reset()
+	if err := printPlan(&b, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "[]\n" {
+		t.Fatalf("unexpected output: %q", b.String())
+	}
+}
@@ -4,12 +4,14 @@ package compiler
 import (
 	"errors"
 	"fmt"
+	"go/build"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/AkihiroSuda/aspectgo/compiler/consts"
 	"github.com/AkihiroSuda/aspectgo/compiler/gopath"
 	"github.com/AkihiroSuda/aspectgo/compiler/parse"
 	"github.com/AkihiroSuda/aspectgo/compiler/weave"
@@ -22,53 +24,92 @@ type Compiler struct {
 
 	// Target is the target package name.
 	// Can contain ... for recursive weaving.
+	// Can be a local package path beginning with ".".
 	Target string
 
+	// Targets are additional target package names.
+	// The format is same as Target.
+	Targets []string
+
+	// Tests enables weaving _test.go files of the target packages,
+	// including the external test packages.
+	Tests bool
+
 	// AspectFilenames are aspect file names.
 	// currently, only single aspect file is supported
 	AspectFilenames []string
+
+	// Strict makes the compilation fail if the pointcut of an aspect
+	// matches no join point in the target packages.
+	// If Strict is false, such an aspect is just reported as a warning.
+	Strict bool
+
+	// WeaveDeps are the import paths of the dependency packages to be woven
+	// as well, e.g. "net/http".
+	// A vendored package can be specified without the vendor directory.
+	// GOROOT packages are woven via the overlay JSON file in WovenGOPATH,
+	// which needs to be passed to `go build -overlay`.
+	// The packages imported by the aspect file cannot be woven.
+	WeaveDeps []string
 }
 
 // Do does all the compilation phases.
+// If the weaver fails to weave a join point, the error is a *weave.Error,
+// which carries the position of the join point.
 func (c *Compiler) Do() error {
 	log.Printf("Phase 0: Checking arguments")
 	if c.WovenGOPATH == "" {
 		return errors.New("WovenGOPATH not specified")
 	}
-	if c.Target == "" {
-		return errors.New("Target not specified")
-	}
-	if len(c.AspectFilenames) != 1 {
-		return fmt.Errorf("only single aspect file is supported at the moment: %v", c.AspectFilenames)
+	oldGOPATH, err := c.checkArgs()
+	if err != nil {
+		return err
 	}
-	aspectFilename := c.AspectFilenames[0]
-	oldGOPATH := os.Getenv("GOPATH")
-	if oldGOPATH == "" {
-		return errors.New("GOPATH not set")
+	if err := gopath.Prepare(c.WovenGOPATH); err != nil {
+		return err
 	}
 
 	log.Printf("Phase 1: Parsing the aspects")
-	aspectFile, err := parse.ParseAspectFile(aspectFilename)
+	aspectFile, err := parse.ParseAspectFile(c.AspectFilenames[0])
 	if err != nil {
 		return err
 	}
 
 	log.Printf("Phase 2: Weaving the aspects to the target packages")
-	targets, err := resolveTarget(oldGOPATH, c.Target)
+	targets, err := c.resolveTargets(oldGOPATH)
+	if err != nil {
+		return err
+	}
+	deps, err := c.resolveDeps(oldGOPATH, targets, aspectFile)
 	if err != nil {
 		return err
 	}
-	var writtenFnames []string
+	var (
+		writtenFnames []string
+		jps           []weave.JoinPoint
+	)
 	for _, target := range targets {
-		w, err := weave.Weave(c.WovenGOPATH, target, aspectFile)
+		w, p, err := weave.Weave(c.WovenGOPATH, target, aspectFile, c.Tests)
 		if err != nil {
 			return err
 		}
 		writtenFnames = append(writtenFnames, w...)
+		jps = append(jps, p...)
+	}
+	for _, dep := range deps {
+		w, p, err := weave.Weave(c.WovenGOPATH, dep, aspectFile, false)
+		if err != nil {
+			return err
+		}
+		writtenFnames = append(writtenFnames, w...)
+		jps = append(jps, p...)
+	}
+	if err := c.checkUnmatched(aspectFile, jps); err != nil {
+		return err
 	}
 	if len(writtenFnames) == 0 {
-		log.Printf("Nothing to do")
-		return nil
+		// we still fix up GOPATH so that WovenGOPATH is always buildable
+		log.Printf("Nothing to weave")
 	}
 
 	log.Printf("Phase 3: Fixing up GOPATH")
@@ -76,14 +117,193 @@ func (c *Compiler) Do() error {
 	if err != nil {
 		return err
 	}
+	overlay, err := gopath.WriteOverlay(build.Default.GOROOT, c.WovenGOPATH)
+	if err != nil {
+		return err
+	}
+	if overlay != "" {
+		log.Printf("GOROOT packages are woven. Use `go build -overlay=%s`", overlay)
+	}
 	return nil
 }
 
+// Plan does the parsing phase and the matching phase, and returns the join points
+// to be woven.
+// Plan does not write anything. WovenGOPATH is ignored.
+func (c *Compiler) Plan() ([]weave.JoinPoint, error) {
+	log.Printf("Phase 0: Checking arguments")
+	oldGOPATH, err := c.checkArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Phase 1: Parsing the aspects")
+	aspectFile, err := parse.ParseAspectFile(c.AspectFilenames[0])
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Phase 2: Matching the aspects with the target packages")
+	targets, err := c.resolveTargets(oldGOPATH)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := c.resolveDeps(oldGOPATH, targets, aspectFile)
+	if err != nil {
+		return nil, err
+	}
+	var jps []weave.JoinPoint
+	for _, target := range targets {
+		p, err := weave.Plan(target, aspectFile, c.Tests)
+		if err != nil {
+			return nil, err
+		}
+		jps = append(jps, p...)
+	}
+	for _, dep := range deps {
+		p, err := weave.Plan(dep, aspectFile, false)
+		if err != nil {
+			return nil, err
+		}
+		jps = append(jps, p...)
+	}
+	if err := c.checkUnmatched(aspectFile, jps); err != nil {
+		return nil, err
+	}
+	return jps, nil
+}
+
+// checkUnmatched reports the aspects whose pointcut matches no join point.
+// A typo in a pointcut regexp results in such an aspect.
+// If c.Strict is true, checkUnmatched returns an error for them.
+func (c *Compiler) checkUnmatched(af *parse.AspectFile, jps []weave.JoinPoint) error {
+	matched := make(map[string]bool)
+	for _, jp := range jps {
+		matched[jp.Aspect] = true
+		for _, asp := range jp.Overridden {
+			matched[asp] = true
+		}
+	}
+	var unmatched []string
+	for asp, pointcut := range af.Pointcuts {
+		name := asp.Obj().Name()
+		if !matched[name] {
+			unmatched = append(unmatched, fmt.Sprintf("%s (pointcut %q)", name, pointcut))
+		}
+	}
+	if len(unmatched) == 0 {
+		return nil
+	}
+	sort.Strings(unmatched)
+	if c.Strict {
+		return fmt.Errorf("pointcut matched no join point: %s", strings.Join(unmatched, ", "))
+	}
+	for _, s := range unmatched {
+		log.Printf("WARNING: pointcut matched no join point: %s", s)
+	}
+	return nil
+}
+
+// checkArgs checks the arguments except WovenGOPATH, and returns GOPATH.
+func (c *Compiler) checkArgs() (string, error) {
+	if c.Target == "" && len(c.Targets) == 0 {
+		return "", errors.New("Target not specified")
+	}
+	if len(c.AspectFilenames) != 1 {
+		return "", fmt.Errorf("only single aspect file is supported at the moment: %v", c.AspectFilenames)
+	}
+	oldGOPATH := os.Getenv("GOPATH")
+	if oldGOPATH == "" {
+		return "", errors.New("GOPATH not set")
+	}
+	return oldGOPATH, nil
+}
+
+func (c *Compiler) resolveTargets(gopath string) ([]string, error) {
+	var targets []string
+	for _, t := range c.allTargets() {
+		resolved, err := resolveTarget(gopath, t)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, resolved...)
+	}
+	return targets, nil
+}
+
+// resolveDeps resolves c.WeaveDeps to the import paths of the packages.
+// A vendored package is resolved against the directories of targets.
+// The packages in targets are excluded.
+func (c *Compiler) resolveDeps(gopath string, targets []string, af *parse.AspectFile) ([]string, error) {
+	forbidden := make(map[string]bool)
+	for pkg := range af.Program.AllPackages {
+		forbidden[pkg.Path()] = true
+	}
+	forbidden[consts.AspectGoPackagePath+"/aspect/rt"] = true
+	isTarget := make(map[string]bool)
+	for _, target := range targets {
+		isTarget[target] = true
+	}
+	resolved := make(map[string]bool)
+	var deps []string
+	for _, dep := range c.WeaveDeps {
+		// vendored packages can differ among the targets
+		for _, target := range targets {
+			bpkg, err := build.Import(dep, filepath.Join(gopath, "src", target), build.FindOnly)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve the dependency %s: %s", dep, err)
+			}
+			path := bpkg.ImportPath
+			if forbidden[path] {
+				return nil, fmt.Errorf("cannot weave %s: it is imported by the aspect file", path)
+			}
+			if isTarget[path] || resolved[path] {
+				continue
+			}
+			resolved[path] = true
+			deps = append(deps, path)
+		}
+	}
+	return deps, nil
+}
+
+func (c *Compiler) allTargets() []string {
+	var targets []string
+	if c.Target != "" {
+		targets = append(targets, c.Target)
+	}
+	return append(targets, c.Targets...)
+}
+
+// ImportPath returns the import path for target.
+// A local package path beginning with "." is resolved against the current
+// directory, which needs to be located under gopath.
+// Other targets are returned as-is.
+func ImportPath(gopath, target string) (string, error) {
+	if !strings.HasPrefix(target, ".") {
+		return target, nil
+	}
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	gopathSrc := filepath.Join(gopath, "src")
+	rel, err := filepath.Rel(gopathSrc, abs)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("local package %s is not under %s", target, gopathSrc)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
 // resolveTarget resolves target that can contain ... and returns the list of
 // resolved packages.
 func resolveTarget(gopath, target string) ([]string, error) {
-	if strings.HasPrefix(target, ".") {
-		return nil, errors.New("local package (.) is not supported yet")
+	target, err := ImportPath(gopath, target)
+	if err != nil {
+		return nil, err
 	}
 	if filepath.Base(target) != "..." {
 		return []string{target}, nil
@@ -91,7 +311,7 @@ func resolveTarget(gopath, target string) ([]string, error) {
 	gopathSrc := filepath.Join(gopath, "src")
 	d := filepath.Join(gopathSrc, filepath.Dir(target))
 	resolvedMap := make(map[string]struct{})
-	err := filepath.Walk(d,
+	err = filepath.Walk(d,
 		func(path string, info os.FileInfo, err error) error {
 			if strings.HasSuffix(path, ".go") {
 				d := filepath.Dir(path)
@@ -2,6 +2,7 @@
 package gopath
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,17 +11,100 @@ import (
 )
 
 // FileForNewGOPATH returns the path for new wovenGOPATH (s/oldGOPATH/wovenGOPATH).
+// s needs to be located under oldGOPATH.
 func FileForNewGOPATH(s, oldGOPATH, wovenGOPATH string) (*os.File, error) {
-	n := strings.Replace(s, oldGOPATH, wovenGOPATH, 1)
+	if !isUnder(s, oldGOPATH) {
+		return nil, fmt.Errorf("%s is not under GOPATH %s", s, oldGOPATH)
+	}
+	return create(wovenGOPATH, strings.Replace(s, oldGOPATH, wovenGOPATH, 1))
+}
+
+// overlayDir is the directory in wovenGOPATH for the woven files of
+// GOROOT packages.
+// GOROOT packages cannot be replaced via GOPATH, so the woven files are
+// used via `go build -overlay` instead.
+const overlayDir = ".aspectgo-overlay"
+
+// overlayJSON is the file in wovenGOPATH for `go build -overlay`.
+const overlayJSON = ".aspectgo-overlay.json"
+
+// FileForOverlay returns the path in wovenGOPATH for the GOROOT file s.
+// The file is used via the overlay JSON file written by WriteOverlay.
+func FileForOverlay(s, goroot, wovenGOPATH string) (*os.File, error) {
+	gorootSrc := filepath.Join(goroot, "src")
+	if !isUnder(s, gorootSrc) {
+		return nil, fmt.Errorf("%s is not under GOROOT %s", s, goroot)
+	}
+	rel, err := filepath.Rel(gorootSrc, s)
+	if err != nil {
+		return nil, err
+	}
+	return create(wovenGOPATH, filepath.Join(wovenGOPATH, overlayDir, rel))
+}
+
+// WriteOverlay writes the overlay JSON file for the files created by
+// FileForOverlay, and returns the name of the JSON file.
+// If there is no such file, WriteOverlay returns an empty string.
+func WriteOverlay(goroot, wovenGOPATH string) (string, error) {
+	d := filepath.Join(wovenGOPATH, overlayDir)
+	if dexists, _ := exists(d); !dexists {
+		return "", nil
+	}
+	replace := make(map[string]string)
+	err := filepath.Walk(d, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(d, path)
+		if err != nil {
+			return err
+		}
+		replace[filepath.Join(goroot, "src", rel)] = path
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(struct{ Replace map[string]string }{replace}, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(wovenGOPATH, overlayJSON)
+	return name, ioutil.WriteFile(name, b, 0644)
+}
+
+// Overlay returns the overlay JSON file written by WriteOverlay.
+// If wovenGOPATH has no overlay JSON file, Overlay returns an empty string.
+func Overlay(wovenGOPATH string) string {
+	name := filepath.Join(wovenGOPATH, overlayJSON)
+	if nexists, _ := exists(name); !nexists {
+		return ""
+	}
+	return name
+}
+
+// isUnder returns true if s is located under dir.
+func isUnder(s, dir string) bool {
+	rel, err := filepath.Rel(dir, s)
+	return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+}
+
+// create creates the file n in wovenGOPATH, with the parent directories.
+// The symlinks to the original directories made by FixUp on the previous
+// weaving are replaced with real directories, so that the original files
+// are never overwritten.
+func create(wovenGOPATH, n string) (*os.File, error) {
 	d := filepath.Dir(n)
+	if err := unfoldSymlinks(wovenGOPATH, d); err != nil {
+		return nil, err
+	}
 	dexists, _ := exists(d)
 	if !dexists {
 		if err := os.MkdirAll(d, 0755); err != nil {
 			return nil, err
 		}
 	}
-	nexists, _ := exists(n)
-	if nexists {
+	if _, err := os.Lstat(n); err == nil {
 		// n may be existing symlink.
 		// we remove symlink here to avoid overwriting.
 		// even if n is non-symlink, it's OK to remove here.
@@ -31,6 +115,50 @@ func FileForNewGOPATH(s, oldGOPATH, wovenGOPATH string) (*os.File, error) {
 	return os.Create(n)
 }
 
+// unfoldSymlinks replaces each symlink to a directory in the path from
+// wovenGOPATH to d with a real directory that contains the symlinks to the
+// children of the original directory.
+func unfoldSymlinks(wovenGOPATH, d string) error {
+	rel, err := filepath.Rel(wovenGOPATH, d)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return err
+	}
+	p := wovenGOPATH
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(p)
+		if err != nil {
+			return err
+		}
+		children, err := ioutil.ReadDir(target)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+		for _, c := range children {
+			if err := os.Symlink(filepath.Join(target, c.Name()), filepath.Join(p, c.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 type fixUpAction string
 
 const (
@@ -92,6 +220,40 @@ ret:
 	return act, ocFullName, wcFullName, nil
 }
 
+// wovenMarker is the name of the file that marks a directory as a woven GOPATH.
+const wovenMarker = ".aspectgo-woven"
+
+// Prepare prepares wovenGOPATH for the weaving phase.
+// wovenGOPATH is created if it does not exist.
+// An existing woven GOPATH is reused as is, so that the packages woven
+// previously are kept. Use Clean for starting from scratch.
+// If wovenGOPATH is a non-empty directory that is not a woven GOPATH,
+// Prepare returns an error rather than removing it.
+func Prepare(wovenGOPATH string) error {
+	if isWoven, _ := exists(filepath.Join(wovenGOPATH, wovenMarker)); isWoven {
+		return nil
+	}
+	if children, err := ioutil.ReadDir(wovenGOPATH); err == nil && len(children) != 0 {
+		return fmt.Errorf("%s is not a woven GOPATH but not empty. please remove it manually", wovenGOPATH)
+	}
+	if err := os.MkdirAll(wovenGOPATH, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(wovenGOPATH, wovenMarker), nil, 0644)
+}
+
+// Clean removes wovenGOPATH.
+// If wovenGOPATH is not a woven GOPATH, Clean returns an error rather than removing it.
+func Clean(wovenGOPATH string) error {
+	if dexists, _ := exists(wovenGOPATH); !dexists {
+		return nil
+	}
+	if isWoven, _ := exists(filepath.Join(wovenGOPATH, wovenMarker)); !isWoven {
+		return fmt.Errorf("%s is not a woven GOPATH. please remove it manually", wovenGOPATH)
+	}
+	return os.RemoveAll(wovenGOPATH)
+}
+
 // FixUp fixes up GOPATH after the weaving phase.
 // It makes some symbolic links from wovenDir to oldDir so that
 // the woven package can be built with wovenDir as GOPATH.
@@ -0,0 +1,98 @@
+package gopath
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agtestgopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	woven := filepath.Join(dir, "woven")
+	if err := Prepare(woven); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(woven, "src", "kept.go")
+	if err := os.MkdirAll(filepath.Dir(kept), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(kept, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the existing woven GOPATH is reused
+	if err := Prepare(woven); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := exists(kept); !ok {
+		t.Fatal("expected the woven GOPATH to be reused")
+	}
+	if err := Clean(woven); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := exists(woven); ok {
+		t.Fatal("expected the woven GOPATH to be removed")
+	}
+
+	// a non-empty directory that is not a woven GOPATH
+	if err := ioutil.WriteFile(filepath.Join(dir, "foo"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prepare(dir); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestFileForNewGOPATHSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agtestgopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old, woven := filepath.Join(dir, "old"), filepath.Join(dir, "woven")
+	orig := filepath.Join(old, "src", "foo", "main.go")
+	other := filepath.Join(old, "src", "foo", "other.go")
+	if err := os.MkdirAll(filepath.Dir(orig), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{orig, other} {
+		if err := ioutil.WriteFile(f, []byte("original"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the directory symlinked by FixUp on the previous weaving
+	if err := os.MkdirAll(filepath.Join(woven, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(old, "src", "foo"), filepath.Join(woven, "src", "foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := FileForNewGOPATH(orig, old, woven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("woven")
+	f.Close()
+	for name, expected := range map[string]string{
+		orig:  "original",
+		other: "original",
+		filepath.Join(woven, "src", "foo", "main.go"):  "woven",
+		filepath.Join(woven, "src", "foo", "other.go"): "original",
+	} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("%s: expected %q, got %q", name, expected, b)
+		}
+	}
+	if fi, err := os.Lstat(filepath.Join(woven, "src", "foo")); err != nil || !fi.IsDir() {
+		t.Fatalf("expected a real directory: %v", err)
+	}
+}
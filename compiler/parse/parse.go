@@ -4,7 +4,10 @@ package parse
 import (
 	"fmt"
 	"go/parser"
+	"go/token"
 	"go/types"
+	"path/filepath"
+	"regexp"
 
 	"golang.org/x/tools/go/loader"
 
@@ -20,10 +23,17 @@ type AspectFile struct {
 	Program   *loader.Program
 	PkgInfo   *loader.PackageInfo
 	Pointcuts map[*types.Named]aspect.Pointcut
+	// Matchers are the compiled regexps for Pointcuts.
+	Matchers map[*types.Named]*regexp.Regexp
 }
 
 // ParseAspectFile parses an aspect file.
 func ParseAspectFile(aspectFilename string) (*AspectFile, error) {
+	// the loader reports absolute file names
+	aspectFilename, err := filepath.Abs(aspectFilename)
+	if err != nil {
+		return nil, err
+	}
 	prog, pkgInfo, err := _parseAspectFile(aspectFilename)
 	if err != nil {
 		return nil, err
@@ -45,14 +55,48 @@ func ParseAspectFile(aspectFilename string) (*AspectFile, error) {
 		Program:   prog,
 		PkgInfo:   pkgInfo,
 		Pointcuts: make(map[*types.Named]aspect.Pointcut),
+		Matchers:  make(map[*types.Named]*regexp.Regexp),
 	}
 	err = aspectFile.determinePointcuts(aspects)
 	if err != nil {
 		return nil, err
 	}
+	err = aspectFile.compilePointcuts()
+	if err != nil {
+		return nil, err
+	}
 	return aspectFile, nil
 }
 
+// compilePointcuts compiles the pointcut regexps.
+// An invalid regexp results in an error with the position of the Pointcut() method.
+func (af *AspectFile) compilePointcuts() error {
+	for asp, pointcut := range af.Pointcuts {
+		re, err := regexp.Compile(string(pointcut))
+		if err != nil {
+			return fmt.Errorf("%s: invalid pointcut %q for aspect %s: %s",
+				af.methodPosition(asp, "Pointcut"), pointcut, asp.Obj().Name(), err)
+		}
+		af.Matchers[asp] = re
+	}
+	return nil
+}
+
+// AdvicePosition returns the position of the Advice() method of asp.
+func (af *AspectFile) AdvicePosition(asp *types.Named) token.Position {
+	return af.methodPosition(asp, "Advice")
+}
+
+// methodPosition returns the position of the method of asp.
+// If the method is not found, it returns the position of asp.
+func (af *AspectFile) methodPosition(asp *types.Named, name string) token.Position {
+	mset := types.NewMethodSet(types.NewPointer(asp))
+	if sel := mset.Lookup(asp.Obj().Pkg(), name); sel != nil {
+		return af.Program.Fset.Position(sel.Obj().Pos())
+	}
+	return af.Program.Fset.Position(asp.Obj().Pos())
+}
+
 func _parseAspectFile(aspectFilename string) (*loader.Program, *loader.PackageInfo, error) {
 	conf := loader.Config{
 		ParserMode: parser.ParseComments,
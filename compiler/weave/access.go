@@ -0,0 +1,176 @@
+package weave
+
+import (
+	"go/ast"
+	"go/build"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+
+	"github.com/AkihiroSuda/aspectgo/compiler/weave/match"
+)
+
+// checkAccessible returns an *Error of ErrType if the signature of a join
+// point refers to a type that cannot be referred from the package of the call
+// site, e.g. an unexported type of another package or a type of an internal
+// package. The proxy for such a join point would not compile.
+// The join points are checked in the order of the positions.
+func checkAccessible(prog *loader.Program, matched map[*ast.Ident]types.Object) error {
+	var ids []*ast.Ident
+	for id := range matched {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		pi, pj := prog.Fset.Position(ids[i].Pos()), prog.Fset.Position(ids[j].Pos())
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	for _, id := range ids {
+		obj := matched[id]
+		sig, ok := obj.Type().(*types.Signature)
+		if !ok {
+			continue
+		}
+		if inst, ok := match.Instance(prog, id); ok {
+			sig = inst.Type.(*types.Signature)
+		}
+		from := callSitePackage(prog, id)
+		if from == nil {
+			continue
+		}
+		filename := prog.Fset.Position(id.Pos()).Filename
+		goroot := strings.HasPrefix(filename, filepath.Join(build.Default.GOROOT, "src")+string(filepath.Separator))
+		typs := []types.Type{sig.Params(), sig.Results()}
+		if sig.Recv() != nil {
+			typs = append(typs, sig.Recv().Type())
+		}
+		for _, typ := range typs {
+			if tn, reason := inaccessibleType(typ, from, goroot); tn != nil {
+				return newError(prog.Fset, id.Pos(), obj, ErrType,
+					"cannot refer to %s in the proxy: %s", types.TypeString(tn.Type(), nil), reason)
+			}
+		}
+	}
+	return nil
+}
+
+// callSitePackage returns the package that uses id.
+func callSitePackage(prog *loader.Program, id *ast.Ident) *types.Package {
+	for _, pkgInfo := range prog.InitialPackages() {
+		if _, ok := pkgInfo.Uses[id]; ok {
+			return pkgInfo.Pkg
+		}
+	}
+	return nil
+}
+
+// inaccessibleType returns the type name referred from typ that cannot be
+// referred from the package from, and the reason.
+// goroot is true if from is a GOROOT package.
+// The underlying types of named types are not checked, as they are not
+// rendered in the generated code.
+func inaccessibleType(typ types.Type, from *types.Package, goroot bool) (*types.TypeName, string) {
+	check := func(tn *types.TypeName) (*types.TypeName, string) {
+		pkg := tn.Pkg()
+		if pkg == nil || pkg == from {
+			return nil, ""
+		}
+		if !tn.Exported() {
+			return tn, "not exported by package " + pkg.Path()
+		}
+		if !internalAllowed(pkg.Path(), from.Path(), goroot) {
+			return tn, "use of internal package " + pkg.Path() + " not allowed"
+		}
+		return nil, ""
+	}
+	typeArgs := func(targs *types.TypeList) (*types.TypeName, string) {
+		for i := 0; i < targs.Len(); i++ {
+			if tn, reason := inaccessibleType(targs.At(i), from, goroot); tn != nil {
+				return tn, reason
+			}
+		}
+		return nil, ""
+	}
+	switch t := typ.(type) {
+	case *types.Named:
+		if tn, reason := check(t.Obj()); tn != nil {
+			return tn, reason
+		}
+		return typeArgs(t.TypeArgs())
+	case *types.Alias:
+		if tn, reason := check(t.Obj()); tn != nil {
+			return tn, reason
+		}
+		return typeArgs(t.TypeArgs())
+	case *types.Pointer:
+		return inaccessibleType(t.Elem(), from, goroot)
+	case *types.Slice:
+		return inaccessibleType(t.Elem(), from, goroot)
+	case *types.Array:
+		return inaccessibleType(t.Elem(), from, goroot)
+	case *types.Chan:
+		return inaccessibleType(t.Elem(), from, goroot)
+	case *types.Map:
+		if tn, reason := inaccessibleType(t.Key(), from, goroot); tn != nil {
+			return tn, reason
+		}
+		return inaccessibleType(t.Elem(), from, goroot)
+	case *types.Signature:
+		if tn, reason := inaccessibleType(t.Params(), from, goroot); tn != nil {
+			return tn, reason
+		}
+		return inaccessibleType(t.Results(), from, goroot)
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			if tn, reason := inaccessibleType(t.At(i).Type(), from, goroot); tn != nil {
+				return tn, reason
+			}
+		}
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if tn, reason := inaccessibleType(t.Field(i).Type(), from, goroot); tn != nil {
+				return tn, reason
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			if tn, reason := inaccessibleType(t.ExplicitMethod(i).Type(), from, goroot); tn != nil {
+				return tn, reason
+			}
+		}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			if tn, reason := inaccessibleType(t.EmbeddedType(i), from, goroot); tn != nil {
+				return tn, reason
+			}
+		}
+	}
+	return nil, ""
+}
+
+// internalAllowed reports whether the package path can be imported from the
+// package from, with regard to the internal packages.
+// The package path ".../a/internal/b" can be imported from ".../a" and its
+// subpackages. The package path "internal/b" can be imported only from the
+// GOROOT packages.
+func internalAllowed(path, from string, goroot bool) bool {
+	// the external test package can import the internal packages of the
+	// package under test
+	from = strings.TrimSuffix(from, "_test")
+	elems := strings.Split(path, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] != "internal" {
+			continue
+		}
+		if i == 0 {
+			return goroot
+		}
+		parent := strings.Join(elems[:i], "/")
+		return from == parent || strings.HasPrefix(from, parent+"/")
+	}
+	return true
+}
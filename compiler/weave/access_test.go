@@ -0,0 +1,65 @@
+package weave
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestInternalAllowed(t *testing.T) {
+	testCases := []struct {
+		path, from string
+		goroot     bool
+		expected   bool
+	}{
+		{"example.com/a/b", "example.com/c", false, true},
+		{"example.com/a/internal/b", "example.com/a", false, true},
+		{"example.com/a/internal/b", "example.com/a/c/d", false, true},
+		{"example.com/a/internal/b", "example.com/a_test", false, true},
+		{"example.com/a/internal/b", "example.com/ab", false, false},
+		{"example.com/a/internal/b", "example.com/c", false, false},
+		{"example.com/a/internal", "example.com/c", false, false},
+		{"internal/b", "os", true, true},
+		{"internal/b", "example.com/c", false, false},
+	}
+	for _, tc := range testCases {
+		if got := internalAllowed(tc.path, tc.from, tc.goroot); got != tc.expected {
+			t.Errorf("internalAllowed(%q, %q, %v): expected %v, got %v",
+				tc.path, tc.from, tc.goroot, tc.expected, got)
+		}
+	}
+}
+
+func TestInaccessibleType(t *testing.T) {
+	from := types.NewPackage("example.com/foo", "foo")
+	lib := types.NewPackage("example.com/lib", "lib")
+	internal := types.NewPackage("example.com/lib/internal/x", "x")
+	named := func(pkg *types.Package, name string) *types.Named {
+		return types.NewNamed(types.NewTypeName(token.NoPos, pkg, name, nil), types.Typ[types.Int], nil)
+	}
+	local := named(from, "local")
+	exported := named(lib, "Exported")
+	unexported := named(lib, "impl")
+	secret := named(internal, "Secret")
+	testCases := []struct {
+		typ      types.Type
+		expected string
+	}{
+		{local, ""},
+		{types.NewPointer(exported), ""},
+		{types.NewPointer(unexported), "impl"},
+		{types.NewMap(types.Typ[types.String], types.NewSlice(secret)), "Secret"},
+		{types.NewSignatureType(nil, nil, nil, nil,
+			types.NewTuple(types.NewVar(token.NoPos, lib, "", unexported)), false), "impl"},
+	}
+	for _, tc := range testCases {
+		tn, reason := inaccessibleType(tc.typ, from, false)
+		got := ""
+		if tn != nil {
+			got = tn.Name()
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected %q, got %q (%s)", tc.typ, tc.expected, got, reason)
+		}
+	}
+}
@@ -2,12 +2,19 @@ package weave
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"fmt"
 	"go/ast"
 	"go/format"
+	"go/token"
+	"go/types"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	rewrite "github.com/tsuna/gorewrite"
 
@@ -17,7 +24,20 @@ import (
 	"github.com/AkihiroSuda/aspectgo/compiler/parse"
 )
 
-func rewriteAspectFile(wovenGOPATH string, af *parse.AspectFile) ([]string, error) {
+// aspectPackage returns the import path of the woven aspect package, which is
+// also used as the package name.
+// The path is derived from the hash of the aspect file, so that the woven
+// packages of different aspect files do not collide with each other.
+func aspectPackage(af *parse.AspectFile) (string, error) {
+	b, err := ioutil.ReadFile(af.Filename)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return fmt.Sprintf("agaspect_%x", h[:8]), nil
+}
+
+func rewriteAspectFile(wovenGOPATH string, af *parse.AspectFile, aspectPkg string) ([]string, error) {
 	// look up *ast.File object
 	var target *ast.File
 	for _, file := range af.PkgInfo.Files {
@@ -31,7 +51,7 @@ func rewriteAspectFile(wovenGOPATH string, af *parse.AspectFile) ([]string, erro
 	}
 	// prepare file name
 	wovenPkgPath := filepath.Join(filepath.Join(wovenGOPATH, "src"),
-		"agaspect")
+		aspectPkg)
 	err := os.MkdirAll(wovenPkgPath, 0755)
 	if err != nil {
 		return nil, err
@@ -46,21 +66,125 @@ func rewriteAspectFile(wovenGOPATH string, af *parse.AspectFile) ([]string, erro
 	// rewrite
 	log.Printf("Rewriting aspect file %s --> %s", af.Filename, outFilename)
 	rw := &aspectFileRewriter{
-		Program: af.Program,
+		Program:     af.Program,
+		PackageName: aspectPkg,
+	}
+	rewritten := rewrite.Rewrite(rw, target).(*ast.File)
+	if rw.err != nil {
+		return nil, rw.err
+	}
+	holders, err := aspectHolders(af, target, rewritten)
+	if err != nil {
+		return nil, err
 	}
-	rewritten := rewrite.Rewrite(rw, target)
 
 	// write the buffer
 	outW := bufio.NewWriter(outFile)
 	outW.Write([]byte(consts.AutogenFileHeader))
-	format.Node(outW, af.Program.Fset, rewritten)
+	printerConfig.Fprint(outW, af.Program.Fset, rewritten)
+	for _, holder := range holders {
+		outW.Write([]byte("\n"))
+		format.Node(outW, af.Program.Fset, holder)
+		outW.Write([]byte("\n"))
+	}
 	outW.Flush()
 	return []string{outFilename}, nil
 }
 
+// holderName returns the name of the variable for the rt.Holder of asp,
+// which is declared in the woven aspect package.
+func holderName(asp *types.Named) string {
+	return "XHolder" + asp.Obj().Name()
+}
+
+// aspectHolders generates the rt.Holder variables for the aspects like this:
+// `var XHolderExampleAspect = rt.NewHolder("ExampleAspect", func() asp.Aspect { return &ExampleAspect{} })`
+// The import of the runtime package is added to rewritten, which is the
+// rewritten file of file.
+func aspectHolders(af *parse.AspectFile, file, rewritten *ast.File) ([]ast.Decl, error) {
+	pkg := af.PkgInfo.Pkg
+	used := make(map[string]bool)
+	for id := range af.PkgInfo.Defs {
+		used[id.Name] = true
+	}
+	for id := range af.PkgInfo.Uses {
+		used[id.Name] = true
+	}
+	fi := newFileImports(pkg, file, used)
+	aspectIntf := lookupAspectInterface(af)
+	if aspectIntf == nil {
+		return nil, &Error{Category: ErrImpl, Message: "aspect interface not found"}
+	}
+	rtPkg := types.NewPackage(consts.AspectGoPackagePath+"/aspect/rt", "rt")
+
+	var aspects []*types.Named
+	for asp := range af.Pointcuts {
+		aspects = append(aspects, asp)
+	}
+	sort.Slice(aspects, func(i, j int) bool {
+		return aspects[i].Obj().Name() < aspects[j].Obj().Name()
+	})
+	var decls []ast.Decl
+	for _, asp := range aspects {
+		name := holderName(asp)
+		if obj := pkg.Scope().Lookup(name); obj != nil {
+			return nil, newError(af.Program.Fset, obj.Pos(), nil, ErrUnsupported,
+				"%s is reserved for the holder of aspect %s", name, asp.Obj().Name())
+		}
+		newFunc := &ast.FuncLit{
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{List: []*ast.Field{
+					{Type: ast.NewIdent(types.TypeString(aspectIntf, fi.qualifier))},
+				}},
+			},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.ReturnStmt{Results: []ast.Expr{
+					&ast.UnaryExpr{
+						Op: token.AND,
+						X:  &ast.CompositeLit{Type: ast.NewIdent(asp.Obj().Name())},
+					}}},
+			}},
+		}
+		holder := &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent(fi.qualifier(rtPkg)),
+				Sel: ast.NewIdent("NewHolder"),
+			},
+			Args: []ast.Expr{
+				&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(asp.Obj().Name())},
+				newFunc,
+			}}
+		decls = append(decls, &ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent(name)},
+				Values: []ast.Expr{holder},
+			}}})
+	}
+	fi.addTo(rewritten)
+	return decls, nil
+}
+
+// lookupAspectInterface returns the aspect.Aspect interface.
+func lookupAspectInterface(af *parse.AspectFile) types.Type {
+	for pkg := range af.Program.AllPackages {
+		if pkg.Path() == consts.AspectGoPackagePath+"/aspect" {
+			if obj, ok := pkg.Scope().Lookup("Aspect").(*types.TypeName); ok {
+				return obj.Type()
+			}
+		}
+	}
+	return nil
+}
+
 // aspectFileRewriter implements rewrite.Rewriter
 type aspectFileRewriter struct {
 	Program *loader.Program
+	// PackageName is the name of the woven aspect package.
+	PackageName string
+	// err is set when the rewriting failed.
+	err error
 }
 
 func (r *aspectFileRewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter) {
@@ -68,12 +192,38 @@ func (r *aspectFileRewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter)
 	case *ast.File:
 		oldName := n.Name.Name
 		if oldName != "main" {
-			log.Fatalf("impl error: why not main? this is unexpected and critical: %s: %v", oldName, n)
+			r.err = newError(r.Program.Fset, n.Name.Pos(), nil, ErrImpl,
+				"why not main? this is unexpected and critical: %s", oldName)
+			return node, nil
 		}
-		newName := "agaspect"
 		rewritten := *n
-		rewritten.Name = ast.NewIdent(newName)
+		rewritten.Name = ast.NewIdent(r.PackageName)
+		rewritten.Comments = stripBuildConstraints(n)
 		return &rewritten, r
 	}
 	return node, r
 }
+
+// stripBuildConstraints returns the comments of f excluding build constraints.
+// An aspect file can have `// +build ignore` so that it is not built as a part
+// of the target package, but the woven aspect package needs to be built.
+func stripBuildConstraints(f *ast.File) []*ast.CommentGroup {
+	var comments []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		if cg.Pos() < f.Package && isBuildConstraint(cg) {
+			continue
+		}
+		comments = append(comments, cg)
+	}
+	return comments
+}
+
+func isBuildConstraint(cg *ast.CommentGroup) bool {
+	for _, c := range cg.List {
+		if !strings.HasPrefix(c.Text, "// +build") &&
+			!strings.HasPrefix(c.Text, "//go:build") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package weave
+
+import (
+	"fmt"
+	"go/token"
+	"go/types"
+)
+
+// ErrorCategory is the category of Error.
+type ErrorCategory string
+
+const (
+	// ErrImpl denotes an implementation error of AspectGo.
+	ErrImpl ErrorCategory = "impl error"
+
+	// ErrUnsupported denotes a join point that cannot be woven.
+	ErrUnsupported ErrorCategory = "unsupported"
+
+	// ErrType denotes a type that cannot be referred in the generated code,
+	// e.g. an unexported type of another package.
+	ErrType ErrorCategory = "type error"
+)
+
+// Error is the error type for the weaver.
+// Error() returns a compiler-style message like this:
+//
+//	/gopath/src/example.com/foo/main.go:12:2: impl error: obj not found (func example.com/foo.sayHello(s string))
+type Error struct {
+	// Position is the position of the node being woven.
+	// Position can be invalid if the error is not related to a node.
+	Position token.Position
+
+	// Object is the matched object. Can be nil.
+	Object types.Object
+
+	// Category is the category of the error.
+	Category ErrorCategory
+
+	// Message is the error message.
+	Message string
+}
+
+func (e *Error) Error() string {
+	s := fmt.Sprintf("%s: %s", e.Category, e.Message)
+	if e.Object != nil {
+		s += fmt.Sprintf(" (%s)", e.Object)
+	}
+	if e.Position.IsValid() {
+		s = fmt.Sprintf("%s: %s", e.Position, s)
+	}
+	return s
+}
+
+// newError creates an Error for the node at pos.
+func newError(fset *token.FileSet, pos token.Pos, obj types.Object, category ErrorCategory, format string, args ...interface{}) *Error {
+	e := &Error{
+		Object:   obj,
+		Category: category,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if fset != nil && pos.IsValid() {
+		e.Position = fset.Position(pos)
+	}
+	return e
+}
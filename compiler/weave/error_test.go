@@ -0,0 +1,25 @@
+package weave
+
+import (
+	"go/token"
+	"testing"
+)
+
+func TestErrorString(t *testing.T) {
+	fset := token.NewFileSet()
+	f := fset.AddFile("/gopath/src/example.com/foo/main.go", -1, 100)
+	f.SetLines([]int{0, 10, 20})
+	pos := f.Pos(22)
+
+	err := newError(fset, pos, nil, ErrUnsupported, "cannot weave %s", "foo")
+	expected := "/gopath/src/example.com/foo/main.go:3:3: unsupported: cannot weave foo"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+
+	err = newError(fset, token.NoPos, nil, ErrImpl, "nil args")
+	expected = "impl error: nil args"
+	if err.Error() != expected {
+		t.Fatalf("expected %q, got %q", expected, err.Error())
+	}
+}
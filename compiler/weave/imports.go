@@ -0,0 +1,150 @@
+package weave
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+// fileImports manages the import names of a woven file.
+// fileImports.qualifier is used as types.Qualifier for rendering types in
+// the generated code.
+// The imports for the packages not imported in the original file are added
+// with collision-free names, and they are written to the woven file by
+// fileImports.addTo().
+type fileImports struct {
+	pkg *types.Package
+	// names maps the import path to the name in the file.
+	// The name is "." for dot imports.
+	// The import path is the one in the source, i.e. without the vendor
+	// directory.
+	names map[string]string
+	// used is the set of the identifiers that cannot be used as the name
+	// of an added import.
+	used map[string]bool
+	// added are the added imports.
+	added []*ast.ImportSpec
+}
+
+// newFileImports creates fileImports for file in pkg.
+// used is the set of the identifiers used in pkg. Names of the added imports
+// are also added to used.
+// reserved are the names reserved for the generated imports, e.g. "aspectrt".
+func newFileImports(pkg *types.Package, file *ast.File, used map[string]bool, reserved ...string) *fileImports {
+	fi := &fileImports{
+		pkg:   pkg,
+		names: make(map[string]string),
+		used:  used,
+	}
+	for _, name := range reserved {
+		fi.used[name] = true
+	}
+	for _, imp := range file.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		var name string
+		if imp.Name != nil {
+			name = imp.Name.Name
+		} else if imported := importedPackage(pkg, path); imported != nil {
+			// the package name can differ from the last element of the path
+			name = imported.Name()
+		} else {
+			continue
+		}
+		if name == "_" {
+			continue
+		}
+		// prefer the named import to the dot import of the same path
+		if old, ok := fi.names[path]; !ok || old == "." {
+			fi.names[path] = name
+		}
+	}
+	return fi
+}
+
+// importedPackage returns the package for path imported by pkg.
+func importedPackage(pkg *types.Package, path string) *types.Package {
+	for _, imported := range pkg.Imports() {
+		if importPath(imported) == path {
+			return imported
+		}
+	}
+	return nil
+}
+
+// importPath returns the path for importing p.
+// For a vendored package like "example.com/foo/vendor/example.com/bar",
+// the path without the vendor directory is returned.
+// GOROOT packages are vendored in "vendor/".
+func importPath(p *types.Package) string {
+	path := p.Path()
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		return path[i+len("/vendor/"):]
+	}
+	return strings.TrimPrefix(path, "vendor/")
+}
+
+// qualifier implements types.Qualifier.
+// If p is not imported in the file, the import is added.
+func (fi *fileImports) qualifier(p *types.Package) string {
+	if p == nil || p == fi.pkg {
+		return ""
+	}
+	name, ok := fi.names[importPath(p)]
+	if !ok {
+		name = fi.add(p)
+	}
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// add adds the import for p, and returns the name of the import.
+// The package name is used if possible, otherwise "_ag_" is prepended.
+func (fi *fileImports) add(p *types.Package) string {
+	name := p.Name()
+	for i := 0; fi.used[name]; i++ {
+		name = fmt.Sprintf("_ag_%s_%d", p.Name(), i)
+	}
+	fi.used[name] = true
+	fi.names[importPath(p)] = name
+	spec := &ast.ImportSpec{
+		Path: &ast.BasicLit{
+			Kind:  token.STRING,
+			Value: strconv.Quote(importPath(p)),
+		}}
+	if name != p.Name() {
+		spec.Name = ast.NewIdent(name)
+	}
+	fi.added = append(fi.added, spec)
+	return name
+}
+
+// addTo adds the added imports to the woven file f.
+// The imports are located at the package clause line, for the //line
+// directives.
+func (fi *fileImports) addTo(f *ast.File) {
+	if len(fi.added) == 0 {
+		return
+	}
+	var specs []ast.Spec
+	for _, spec := range fi.added {
+		spec.Path.ValuePos = f.Package
+		specs = append(specs, spec)
+	}
+	decl := &ast.GenDecl{
+		TokPos: f.Package,
+		Tok:    token.IMPORT,
+		Lparen: f.Package,
+		Specs:  specs,
+		Rparen: f.Package,
+	}
+	f.Decls = append([]ast.Decl{decl}, f.Decls...)
+	f.Imports = append(f.Imports, fi.added...)
+}
@@ -0,0 +1,93 @@
+package weave
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestFileImportsQualifier(t *testing.T) {
+	src := `package foo
+
+import (
+	"example.com/x"
+	"example.com/x/y"
+	zz "example.com/z"
+	. "example.com/dot"
+	_ "example.com/blank"
+	"example.com/v2"
+	"example.com/vendored"
+)
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "foo.go", src, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := types.NewPackage("example.com/foo", "foo")
+	x := types.NewPackage("example.com/x", "x")
+	y := types.NewPackage("example.com/x/y", "y")
+	z := types.NewPackage("example.com/z", "z")
+	dot := types.NewPackage("example.com/dot", "dot")
+	v2 := types.NewPackage("example.com/v2", "vee")
+	vendored := types.NewPackage("example.com/foo/vendor/example.com/vendored", "vendored")
+	pkg.SetImports([]*types.Package{x, y, z, dot, v2, vendored})
+	vendored2 := types.NewPackage("vendor/example.com/vendored2", "vendored2")
+	errors1 := types.NewPackage("example.com/errors", "errors")
+	errors2 := types.NewPackage("example.com/other/errors", "errors")
+	bar := types.NewPackage("example.com/bar", "bar")
+
+	used := map[string]bool{"bar": true}
+	fi := newFileImports(pkg, file, used, "aspectrt")
+	named := func(p *types.Package, name string) types.Type {
+		return types.NewNamed(types.NewTypeName(token.NoPos, p, name, nil),
+			types.Typ[types.Int], nil)
+	}
+	testCases := []struct {
+		typ      types.Type
+		expected string
+	}{
+		{named(pkg, "T"), "T"},
+		{named(x, "T"), "x.T"},
+		{types.NewPointer(named(y, "T")), "*y.T"},
+		{types.NewSlice(named(z, "T")), "[]zz.T"},
+		{named(dot, "T"), "T"},
+		{named(v2, "T"), "vee.T"},
+		{types.NewMap(named(x, "K"), named(y, "V")), "map[x.K]y.V"},
+		{named(errors1, "T"), "errors.T"},
+		{named(errors2, "T"), "_ag_errors_0.T"},
+		{named(bar, "T"), "_ag_bar_0.T"},
+		{named(vendored, "T"), "vendored.T"},
+		{named(vendored2, "T"), "vendored2.T"},
+		{types.Universe.Lookup("error").Type(), "error"},
+	}
+	for _, tc := range testCases {
+		s := types.TypeString(tc.typ, fi.qualifier)
+		if s != tc.expected {
+			t.Fatalf("expected %q, got %q", tc.expected, s)
+		}
+	}
+
+	woven := &ast.File{Package: file.Package, Name: file.Name, Decls: file.Decls, Imports: file.Imports}
+	fi.addTo(woven)
+	expected := map[string]string{
+		`"example.com/errors"`:       "",
+		`"example.com/other/errors"`: "_ag_errors_0",
+		`"example.com/bar"`:          "_ag_bar_0",
+		`"example.com/vendored2"`:    "",
+	}
+	if len(woven.Imports) != len(file.Imports)+len(expected) {
+		t.Fatalf("unexpected imports: %d", len(woven.Imports))
+	}
+	for _, imp := range woven.Imports[len(file.Imports):] {
+		name, ok := expected[imp.Path.Value]
+		if !ok {
+			t.Fatalf("unexpected import %s", imp.Path.Value)
+		}
+		if (imp.Name == nil && name != "") || (imp.Name != nil && imp.Name.Name != name) {
+			t.Fatalf("unexpected name for %s: %v", imp.Path.Value, imp.Name)
+		}
+	}
+}
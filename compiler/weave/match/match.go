@@ -5,34 +5,71 @@ import (
 	"go/types"
 	"log"
 	"regexp"
+	"strings"
 
 	"golang.org/x/tools/go/loader"
 
-	"github.com/AkihiroSuda/aspectgo/aspect"
 	"github.com/AkihiroSuda/aspectgo/compiler/util"
 )
 
 // ObjMatchPointcut returns true if obj matches the pointcut.
+// matcher is the compiled regexp for the pointcut.
 // current implementation is very naive: just checks regexp for types.Func.FullName()
+// For generic functions and methods of generic types, the regexp is checked for
+// the names with and without the type arguments, e.g. "example.com/foo.Map[int,string]"
+// and "example.com/foo.Map".
 // TODO: support interface pointcut
-func ObjMatchPointcut(prog *loader.Program, id *ast.Ident, obj types.Object, pointcut aspect.Pointcut) bool {
+func ObjMatchPointcut(prog *loader.Program, id *ast.Ident, obj types.Object, matcher *regexp.Regexp) bool {
 	fn, ok := obj.(*types.Func)
-	if ok {
-		return fnObjMatchPointcutByRegexp(fn, pointcut)
+	if !ok {
+		return false
+	}
+	names := []string{fn.FullName()}
+	if inst, ok := Instance(prog, id); ok {
+		names = append(names, InstanceName(fn, inst))
+	}
+	if origin := fn.Origin(); origin != fn {
+		names = append(names, origin.FullName())
+	}
+	for _, name := range names {
+		if fnNameMatchPointcutByRegexp(name, matcher) {
+			return true
+		}
 	}
 	return false
 }
 
-func fnObjMatchPointcutByRegexp(fn *types.Func, pointcut aspect.Pointcut) bool {
-	// TODO: cache compiled regexp
-	re, err := regexp.Compile(string(pointcut))
-	if err != nil {
-		log.Printf("pointcut %s is not a valid regexp: %s", pointcut, err)
-		return false
-	}
-	matched := re.MatchString(fn.FullName())
+func fnNameMatchPointcutByRegexp(name string, matcher *regexp.Regexp) bool {
+	matched := matcher.MatchString(name)
 	if util.DebugMode {
-		log.Printf("matched=%t for %s (pointcut=%s)", matched, fn.FullName(), string(pointcut))
+		log.Printf("matched=%t for %s (pointcut=%s)", matched, name, matcher)
 	}
 	return matched
 }
+
+// Instance returns the instance of the generic function denoted by id.
+// ok is false if id does not denote an instantiated generic function.
+// Methods of generic types are not included, as types.Info.Uses already
+// has the instantiated methods for them.
+func Instance(prog *loader.Program, id *ast.Ident) (inst types.Instance, ok bool) {
+	for _, pkgInfo := range prog.InitialPackages() {
+		if inst, ok = pkgInfo.Instances[id]; ok {
+			return inst, true
+		}
+	}
+	return types.Instance{}, false
+}
+
+// InstanceName returns the full name of fn with the type arguments of inst,
+// e.g. "example.com/foo.Map[int,string]".
+// If inst is the zero value, it just returns fn.FullName().
+func InstanceName(fn *types.Func, inst types.Instance) string {
+	if inst.TypeArgs == nil || inst.TypeArgs.Len() == 0 {
+		return fn.FullName()
+	}
+	var args []string
+	for i := 0; i < inst.TypeArgs.Len(); i++ {
+		args = append(args, types.TypeString(inst.TypeArgs.At(i), nil))
+	}
+	return fn.FullName() + "[" + strings.Join(args, ",") + "]"
+}
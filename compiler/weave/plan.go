@@ -0,0 +1,151 @@
+package weave
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/loader"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/compiler/parse"
+	"github.com/AkihiroSuda/aspectgo/compiler/weave/match"
+)
+
+// JoinPoint is the type for a join point to be woven.
+type JoinPoint struct {
+	// Position is the position of the call site.
+	// The line and the column are the same as aspect.CallSite.Position,
+	// but the file name is the real one.
+	Position token.Position
+
+	// Callee is the full name of the callee. e.g. "fmt.Println"
+	Callee string
+
+	// Aspect is the name of the aspect to be applied.
+	Aspect string
+
+	// Pointcut is the pointcut of the aspect.
+	Pointcut string
+
+	// Overridden is the names of the aspects that also match the join point
+	// but are overridden by Aspect.
+	Overridden []string `json:",omitempty"`
+}
+
+// Plan returns the join points in the target package to be woven with the aspect file.
+// Plan does not write anything.
+// If tests is true, _test.go files (including the external test package) are scanned as well.
+func Plan(target string, af *parse.AspectFile, tests bool) ([]JoinPoint, error) {
+	_, prog, err := loadTarget(target, tests)
+	if err != nil {
+		return nil, err
+	}
+	matched, pointcutsByIdent, overridden, err := findMatchedThings(prog, af)
+	if err != nil {
+		return nil, err
+	}
+	skipUnweavable(prog, matched, pointcutsByIdent, overridden)
+	if err := checkAccessible(prog, matched); err != nil {
+		return nil, err
+	}
+	aspects := pointcutMapToAspectMap(af.Pointcuts)
+	return joinPoints(prog, matched, pointcutsByIdent, overridden, aspects), nil
+}
+
+func joinPoints(prog *loader.Program, matched map[*ast.Ident]types.Object,
+	pointcutsByIdent map[*ast.Ident]aspect.Pointcut,
+	overridden map[*ast.Ident][]aspect.Pointcut,
+	aspects map[aspect.Pointcut]*types.Named) []JoinPoint {
+	jps := []JoinPoint{}
+	positions := joinPointPositions(prog, matched)
+	for id, obj := range matched {
+		pointcut := pointcutsByIdent[id]
+		inst, _ := match.Instance(prog, id)
+		jp := JoinPoint{
+			Position: positions[id],
+			Callee:   calleeName(obj, inst),
+			Aspect:   aspects[pointcut].Obj().Name(),
+			Pointcut: pointcut.String(),
+		}
+		for _, pc := range overridden[id] {
+			jp.Overridden = append(jp.Overridden, aspects[pc].Obj().Name())
+		}
+		jps = append(jps, jp)
+	}
+	sortJoinPoints(jps)
+	return jps
+}
+
+// joinPointPositions returns the positions of the call sites of the join
+// points in matched.
+// The call site of `x.Sel` starts at x, as the selector expression is
+// replaced with the proxy.
+func joinPointPositions(prog *loader.Program, matched map[*ast.Ident]types.Object) map[*ast.Ident]token.Position {
+	positions := make(map[*ast.Ident]token.Position)
+	for _, pkgInfo := range prog.AllPackages {
+		for _, file := range pkgInfo.Files {
+			ast.Inspect(file, func(node ast.Node) bool {
+				var id *ast.Ident
+				switch n := node.(type) {
+				case *ast.SelectorExpr:
+					id = n.Sel
+				case *ast.Ident:
+					id = n
+				}
+				if _, ok := matched[id]; ok {
+					if _, ok := positions[id]; !ok {
+						positions[id] = prog.Fset.Position(node.Pos())
+					}
+				}
+				return true
+			})
+		}
+	}
+	return positions
+}
+
+// calleeName returns the full name of the callee.
+// inst is the instance for the generic function, or the zero value.
+func calleeName(obj types.Object, inst types.Instance) string {
+	if fn, ok := obj.(*types.Func); ok {
+		return match.InstanceName(fn, inst)
+	}
+	return obj.String()
+}
+
+func sortJoinPoints(jps []JoinPoint) {
+	sort.Sort(joinPointsByPosition(jps))
+}
+
+type joinPointsByPosition []JoinPoint
+
+func (s joinPointsByPosition) Len() int      { return len(s) }
+func (s joinPointsByPosition) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s joinPointsByPosition) Less(i, j int) bool {
+	pi, pj := s[i].Position, s[j].Position
+	if pi.Filename != pj.Filename {
+		return pi.Filename < pj.Filename
+	}
+	return pi.Offset < pj.Offset
+}
+
+// MarshalJSON implements json.Marshaler.
+// The offset of Position is omitted, as it is meaningless for the users.
+func (jp JoinPoint) MarshalJSON() ([]byte, error) {
+	type position struct {
+		Filename string
+		Line     int
+		Column   int
+	}
+	type joinPoint JoinPoint
+	return json.Marshal(struct {
+		Position position
+		joinPoint
+	}{
+		Position:  position{jp.Position.Filename, jp.Position.Line, jp.Position.Column},
+		joinPoint: joinPoint(jp),
+	})
+}
@@ -2,13 +2,21 @@ package weave
 
 import (
 	"bufio"
+	"crypto/sha256"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/build/constraint"
 	"go/format"
+	"go/printer"
 	"go/token"
 	"go/types"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	rewrite "github.com/tsuna/gorewrite"
@@ -19,6 +27,7 @@ import (
 	"github.com/AkihiroSuda/aspectgo/compiler/consts"
 	"github.com/AkihiroSuda/aspectgo/compiler/gopath"
 	"github.com/AkihiroSuda/aspectgo/compiler/util"
+	"github.com/AkihiroSuda/aspectgo/compiler/weave/match"
 )
 
 func rewriteProgram(wovenGOPATH string, rw *rewriter) ([]string, error) {
@@ -38,34 +47,237 @@ func rewriteProgram(wovenGOPATH string, rw *rewriter) ([]string, error) {
 			if strings.HasSuffix(posn.Filename, "_aspect.go") {
 				continue
 			}
-			outf, err := gopath.FileForNewGOPATH(posn.Filename,
-				oldGOPATH, wovenGOPATH)
+			rewritten := rewrite.Rewrite(rw, file)
+			if rw.err != nil {
+				return nil, rw.err
+			}
+			if !rw.fileRewritten {
+				// nothing woven. the original file is used via symlink.
+				continue
+			}
+			rewrittenFile := rewritten.(*ast.File)
+			if len(rw.fileAddendum) == 0 {
+				// all the proxies are in the other files of the package.
+				removeImport(rewrittenFile, rw.AspectPackage)
+			}
+			blankUnusedImports(rewrittenFile, rw.AddendumForASTFile(), pkgInfo)
+			rw.fileImports.addTo(rewrittenFile)
+			outf, err := wovenFile(posn.Filename, oldGOPATH, wovenGOPATH)
 			if err != nil {
 				return nil, err
 			}
 			defer outf.Close()
 			log.Printf("Rewriting %s --> %s",
 				posn.Filename, outf.Name())
-			rewritten := rewrite.Rewrite(rw, file)
 			outw := bufio.NewWriter(outf)
 			outw.Write([]byte(consts.AutogenFileHeader))
-			err = format.Node(outw, rw.Program.Fset, rewritten)
+			err = printerConfig.Fprint(outw, rw.Program.Fset, rewritten)
 			if err != nil {
 				return nil, err
 			}
 			for _, add := range rw.AddendumForASTFile() {
 				outw.Write([]byte("\n"))
-				format.Node(outw, rw.Program.Fset, add)
+				writeLineDirective(outw, add.Position)
+				format.Node(outw, rw.Program.Fset, add.Node)
 				outw.Write([]byte("\n"))
 			}
 			outw.Flush()
 			rewrittenFnames = append(rewrittenFnames, outf.Name())
 		}
+		for _, test := range []bool{false, true} {
+			sf, ok := rw.sharedProxies[sharedKey{pkg: pkgInfo.Pkg, test: test}]
+			if !ok {
+				continue
+			}
+			fname, err := writeSharedProxies(pkgInfo, sf, test, oldGOPATH, wovenGOPATH, rw.Program.Fset)
+			if err != nil {
+				return nil, err
+			}
+			rewrittenFnames = append(rewrittenFnames, fname)
+		}
 	}
 	return rewrittenFnames, nil
 }
 
-var gRewriterLastP = 0
+// writeSharedProxies writes the proxies shared by the files of the package
+// to a dedicated file like "zz_aspectgo_proxies.go" in the directory of the
+// package, and returns the name of the written file.
+// So the woven package needs to be built as a package, or with the file
+// listed, e.g. `go run main.go zz_aspectgo_proxies.go`.
+func writeSharedProxies(pkgInfo *loader.PackageInfo, sf *sharedFile, test bool,
+	oldGOPATH, wovenGOPATH string, fset *token.FileSet) (string, error) {
+	base := "zz_aspectgo_proxies"
+	if strings.HasSuffix(pkgInfo.Pkg.Path(), "_test") {
+		// the external test package in the same directory
+		base = "zz_aspectgo_xproxies"
+	}
+	suffix := ".go"
+	if test {
+		suffix = "_test.go"
+	}
+	dir := filepath.Dir(fset.Position(pkgInfo.Files[0].Pos()).Filename)
+	filename := filepath.Join(dir, base+suffix)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			break
+		}
+		filename = filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, suffix))
+	}
+	f := &ast.File{Name: ast.NewIdent(pkgInfo.Pkg.Name())}
+	sf.imports.addTo(f)
+	outf, err := wovenFile(filename, oldGOPATH, wovenGOPATH)
+	if err != nil {
+		return "", err
+	}
+	defer outf.Close()
+	log.Printf("Writing the shared proxies --> %s", outf.Name())
+	outw := bufio.NewWriter(outf)
+	outw.Write([]byte(consts.AutogenFileHeader))
+	if err := format.Node(outw, fset, f); err != nil {
+		return "", err
+	}
+	for _, add := range sf.addenda {
+		outw.Write([]byte("\n"))
+		writeLineDirective(outw, add.Position)
+		format.Node(outw, fset, add.Node)
+		outw.Write([]byte("\n"))
+	}
+	return outf.Name(), outw.Flush()
+}
+
+// hasBuildConstraints reports whether the file may be excluded from the
+// build, i.e. it has a build constraint line like "//go:build !windows", or
+// its name has the GOOS and GOARCH suffixes like "foo_linux.go".
+func hasBuildConstraints(filename string, file *ast.File) bool {
+	for _, cg := range file.Comments {
+		if cg.Pos() >= file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) || constraint.IsPlusBuild(c.Text) {
+				return true
+			}
+		}
+	}
+	// the names with the known GOOS and GOARCH suffixes never match the
+	// unknown ones. The contents are not read, as they are checked above.
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH = "aspectgo", "aspectgo"
+	ctxt.OpenFile = func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("package p\n")), nil
+	}
+	dir, name := filepath.Split(filename)
+	ok, err := ctxt.MatchFile(dir, name)
+	return err != nil || !ok
+}
+
+// blankUnusedImports renames the imports that are no longer used in the woven
+// file f to "_", e.g. "fmt" only used for the calls to fmt.Println that are
+// replaced with the shared proxy.
+// An import is regarded as used if it is referred from f, or if its name is
+// qualifying something in the addenda of f.
+func blankUnusedImports(f *ast.File, addenda []addendum, pkgInfo *loader.PackageInfo) {
+	used := make(map[types.Object]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if pn, ok := pkgInfo.Uses[id].(*types.PkgName); ok {
+				used[pn] = true
+			}
+		}
+		return true
+	})
+	usedNames := make(map[string]bool)
+	for _, add := range addenda {
+		ast.Inspect(add.Node, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					usedNames[id.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	for _, imp := range f.Imports {
+		var obj types.Object
+		if imp.Name != nil {
+			if imp.Name.Name == "." || imp.Name.Name == "_" {
+				continue
+			}
+			obj = pkgInfo.Defs[imp.Name]
+		} else {
+			obj = pkgInfo.Implicits[imp]
+		}
+		pn, ok := obj.(*types.PkgName)
+		if !ok || pn.Imported().Path() == "C" || used[pn] || usedNames[pn.Name()] {
+			continue
+		}
+		imp.Name = &ast.Ident{NamePos: imp.Path.Pos(), Name: "_"}
+	}
+}
+
+// wovenFile creates the woven file for the original file filename.
+// The files of GOROOT packages are created in the overlay directory.
+func wovenFile(filename, oldGOPATH, wovenGOPATH string) (*os.File, error) {
+	goroot := build.Default.GOROOT
+	if strings.HasPrefix(filename, filepath.Join(goroot, "src")+string(filepath.Separator)) {
+		return gopath.FileForOverlay(filename, goroot, wovenGOPATH)
+	}
+	return gopath.FileForNewGOPATH(filename, oldGOPATH, wovenGOPATH)
+}
+
+// removeImport removes the import of path added by rewriter.Rewrite(),
+// e.g. the aspect package for a file that does not refer to any aspect.
+func removeImport(f *ast.File, path string) {
+	isImport := func(spec ast.Spec) bool {
+		imp, ok := spec.(*ast.ImportSpec)
+		return ok && imp.Path.Value == strconv.Quote(path)
+	}
+	var decls []ast.Decl
+	for _, decl := range f.Decls {
+		if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.IMPORT &&
+			len(gd.Specs) == 1 && isImport(gd.Specs[0]) {
+			continue
+		}
+		decls = append(decls, decl)
+	}
+	f.Decls = decls
+	var imports []*ast.ImportSpec
+	for _, imp := range f.Imports {
+		if !isImport(imp) {
+			imports = append(imports, imp)
+		}
+	}
+	f.Imports = imports
+}
+
+// printerConfig is the printer config for woven files.
+// printer.SourcePos emits //line directives so that the woven code is
+// mapped to the original source code in stack traces, debuggers, and
+// coverage reports.
+// Generated nodes need to have valid positions, otherwise bogus //line
+// directives are emitted. Generated addenda are printed with format.Node
+// after writeLineDirective() instead.
+var printerConfig = &printer.Config{
+	Mode:     printer.UseSpaces | printer.TabIndent | printer.SourcePos,
+	Tabwidth: 8,
+}
+
+// writeLineDirective writes a //line directive for the generated code.
+// Nothing is written if pos is invalid.
+func writeLineDirective(w io.Writer, pos token.Position) {
+	if !pos.IsValid() {
+		return
+	}
+	fmt.Fprintf(w, "//line %s:%d\n", pos.Filename, pos.Line)
+}
+
+// addendum is a generated node to be appended to the woven file.
+type addendum struct {
+	Node ast.Node
+	// Position is the synthetic position for Node.
+	// e.g. the position of the Advice() method of the aspect.
+	Position token.Position
+}
 
 // rewriter implements rewrite.Rewriter.
 // usage:
@@ -79,30 +291,245 @@ type rewriter struct {
 	Matched          map[*ast.Ident]types.Object
 	Aspects          map[aspect.Pointcut]*types.Named
 	PointcutsByIdent map[*ast.Ident]aspect.Pointcut
+	// AdvicePositions are the positions of the Advice() methods.
+	// They are used as the synthetic positions of the generated proxies.
+	AdvicePositions map[*types.Named]token.Position
+	// AspectPackage is the import path of the woven aspect package.
+	AspectPackage string
 	// fileAddendum is set by rewriter.Rewrite().
 	// rewriteProgram() uses rewriter.AddendumForASTFile()
 	// as a getter.
-	fileAddendum []ast.Node
-	proxyExprs   map[*ast.Ident]ast.Expr
+	fileAddendum []addendum
+	// fileSites are the call-site variables for the current file.
+	// They are prepended to fileAddendum by rewriter.AddendumForASTFile().
+	fileSites []ast.Spec
+	// fileRewritten is set by rewriter.proxy().
+	// A file can be rewritten without any proxy in fileAddendum, when the
+	// proxies are shared with the other files of the package.
+	fileRewritten bool
+	// fileConstrained is set by rewriter.Rewrite(), if the current file has
+	// build constraints. The proxies for such a file are generated in the
+	// file itself, as the callee can be unavailable for the other builds.
+	fileConstrained bool
+	// proxies are the generated proxies.
+	// Calls to the same callee share the proxy in the package.
+	proxies map[proxyKey]proxyNames
+	// sharedProxies are the proxies shared by the files without build
+	// constraints in each package. They are written to a dedicated file by
+	// rewriteProgram(), so that they do not depend on the imports and the
+	// build constraints of the file calling the callee first.
+	sharedProxies map[sharedKey]*sharedFile
+	// usedNames is the set of the identifiers used in each package,
+	// including the generated ones.
+	// It is used for avoiding name collisions in rewriter.uniqueNames().
+	usedNames map[*types.Package]map[string]bool
 	// currentPkg is set by the loop in rewriteProgram().
 	// It is used for rewriter.typeString().
 	currentPkg *types.Package
 	// currentFile is set by the loop in rewriteProgram().
-	// It is used for rewriter.typeString().
 	currentFile *ast.File
+	// fileImports is set by rewriter.Rewrite().
+	// It is used for rewriter.typeString().
+	fileImports *fileImports
+	// importNames are the names of the generated imports in each package.
+	// currentImportNames is set by rewriter.Rewrite() for the current file.
+	importNames        map[*types.Package]generatedImportNames
+	currentImportNames generatedImportNames
+	// currentNode and currentObj are set by rewriter.proxy().
+	// They are used for rewriter.fail().
+	currentNode ast.Node
+	currentObj  types.Object
+	// currentInst is set by rewriter.proxy(), if currentObj is a generic
+	// function. It is used for rewriter.signature().
+	currentInst types.Instance
+	// currentParams is set by rewriter._proxy().
+	// It is the parameter names returned by rewriter.paramNames().
+	currentParams []string
+	// err is set by rewriter.fail().
+	// Once err is set, rewriter.Rewrite() stops rewriting.
+	err error
 }
 
 func (r *rewriter) init() error {
 	if r.Program == nil || r.Matched == nil ||
 		r.Aspects == nil || r.PointcutsByIdent == nil {
-		log.Fatal("impl error (nil args)")
+		return &Error{Category: ErrImpl, Message: "nil args"}
 	}
 
 	// NOTE: r.fileAddendum is initialized in Rewrite():*ast.File
-	r.proxyExprs = make(map[*ast.Ident]ast.Expr)
+	r.proxies = make(map[proxyKey]proxyNames)
+	r.sharedProxies = make(map[sharedKey]*sharedFile)
+	r.usedNames = make(map[*types.Package]map[string]bool)
+	r.importNames = make(map[*types.Package]generatedImportNames)
 	return nil
 }
 
+// generatedImportNames are the import names of the runtime package and the
+// aspect package.
+type generatedImportNames struct {
+	rt     string
+	aspect string
+}
+
+// generatedImports returns the import names of the runtime package and the
+// aspect package in the current package.
+// The names are chosen not to collide with the identifiers in the package,
+// and they are the same for all the files in the package.
+func (r *rewriter) generatedImports() generatedImportNames {
+	if names, ok := r.importNames[r.currentPkg]; ok {
+		return names
+	}
+	used := r.packageUsedNames()
+	unique := func(base string) string {
+		name := base
+		for i := 0; used[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		used[name] = true
+		return name
+	}
+	names := generatedImportNames{
+		rt:     unique("aspectrt"),
+		aspect: unique(r.AspectPackage),
+	}
+	r.importNames[r.currentPkg] = names
+	return names
+}
+
+// proxyKey is the key for sharing proxies in a package.
+// Proxies generated in a _test.go file cannot be shared with non-test
+// files, as they are not compiled in non-test builds.
+// For generic functions, inst is the string of the type arguments.
+// file is set for the file with build constraints, which does not share
+// the proxies.
+type proxyKey struct {
+	pkg  *types.Package
+	obj  types.Object
+	inst string
+	asp  *types.Named
+	test bool
+	file *ast.File
+}
+
+// sharedKey is the key for the dedicated file of the shared proxies.
+type sharedKey struct {
+	pkg  *types.Package
+	test bool
+}
+
+// sharedFile is the dedicated file of the shared proxies.
+type sharedFile struct {
+	addenda []addendum
+	// imports is used as rewriter.fileImports while generating the
+	// proxies in the file.
+	imports *fileImports
+}
+
+// sharedFileFor returns the dedicated file of the shared proxies for the
+// current package.
+func (r *rewriter) sharedFileFor(test bool) *sharedFile {
+	key := sharedKey{pkg: r.currentPkg, test: test}
+	if sf, ok := r.sharedProxies[key]; ok {
+		return sf
+	}
+	names := r.currentImportNames
+	imports := newFileImports(r.currentPkg, &ast.File{}, r.packageUsedNames(), names.rt, names.aspect)
+	imports.added = []*ast.ImportSpec{
+		{
+			Name: ast.NewIdent(names.rt),
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(consts.AspectGoPackagePath + "/aspect/rt")},
+		},
+		{
+			Name: ast.NewIdent(names.aspect),
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(r.AspectPackage)},
+		},
+	}
+	sf := &sharedFile{imports: imports}
+	r.sharedProxies[key] = sf
+	return sf
+}
+
+// proxyNames are the names of the generated functions for a proxy.
+type proxyNames struct {
+	proxy string
+	pgen  string
+}
+
+// proxyNamesFor returns the names for the proxy of the callee matched with asp.
+// The names are derived from the hash of the package path, the callee, and the
+// aspect, so that the woven output does not depend on the order of the
+// traversal.
+// file is the base name of the file for the proxy that is not shared.
+func (r *rewriter) proxyNamesFor(matched types.Object, asp *types.Named, test bool, file string) proxyNames {
+	names := r.uniqueNames([]string{"_ag_proxy_", "_ag_pgen_"},
+		r.currentPkg.Path(), calleeName(matched, r.currentInst), asp.Obj().Name(), fmt.Sprint(test), file)
+	return proxyNames{proxy: names[0], pgen: names[1]}
+}
+
+// siteName returns the name for the call-site variable of node.
+func (r *rewriter) siteName(node ast.Node, matched types.Object) string {
+	return r.uniqueNames([]string{"_ag_site_"},
+		r.currentPkg.Path(), calleeName(matched, r.currentInst), r.sitePosition(node))[0]
+}
+
+// sitePosition returns the position of node for aspect.CallSite.
+// The file name is prefixed with the import path rather than GOPATH.
+// The import path of the external test package is that of the package under
+// test, as they are in the same directory.
+func (r *rewriter) sitePosition(node ast.Node) string {
+	posn := r.Program.Fset.Position(node.Pos())
+	dir := r.currentPkg.Path()
+	if strings.HasSuffix(r.currentPkg.Name(), "_test") {
+		dir = strings.TrimSuffix(dir, "_test")
+	}
+	return fmt.Sprintf("%s/%s:%d:%d", dir, filepath.Base(posn.Filename), posn.Line, posn.Column)
+}
+
+// uniqueNames returns a name for each prefix, suffixed with the hash of key.
+// The names never collide with the identifiers defined in the package
+// (including local ones) nor with the other generated names.
+func (r *rewriter) uniqueNames(prefixes []string, key ...string) []string {
+	h := sha256.Sum256([]byte(strings.Join(key, "\x00")))
+	suffix := fmt.Sprintf("%x", h[:8])
+	used := r.packageUsedNames()
+	for i := 0; ; i++ {
+		var names []string
+		collides := false
+		for _, prefix := range prefixes {
+			name := prefix + suffix
+			if i > 0 {
+				name = fmt.Sprintf("%s_%d", name, i)
+			}
+			collides = collides || used[name]
+			names = append(names, name)
+		}
+		if !collides {
+			for _, name := range names {
+				used[name] = true
+			}
+			return names
+		}
+	}
+}
+
+// packageUsedNames returns the set of the identifiers used in r.currentPkg.
+func (r *rewriter) packageUsedNames() map[string]bool {
+	used, ok := r.usedNames[r.currentPkg]
+	if ok {
+		return used
+	}
+	used = make(map[string]bool)
+	pkgInfo := r.Program.AllPackages[r.currentPkg]
+	for id := range pkgInfo.Defs {
+		used[id.Name] = true
+	}
+	for id := range pkgInfo.Uses {
+		used[id.Name] = true
+	}
+	r.usedNames[r.currentPkg] = used
+	return used
+}
+
 func voidIntfArrayExpr() *ast.ArrayType {
 	return &ast.ArrayType{
 		Elt: &ast.InterfaceType{
@@ -110,15 +537,36 @@ func voidIntfArrayExpr() *ast.ArrayType {
 		}}
 }
 
+// siteField returns the parameter for the call-site variable.
+func (r *rewriter) siteField() *ast.Field {
+	return &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent("_ag_site")},
+		Type: &ast.StarExpr{
+			X: &ast.SelectorExpr{
+				X:   ast.NewIdent(r.currentImportNames.rt),
+				Sel: ast.NewIdent("CallSite"),
+			}}}
+}
+
+// proxyExtraParams returns the number of the parameters of the proxy
+// that precede the parameters of the callee.
+func proxyExtraParams(sig *types.Signature) int {
+	if sig.Recv() != nil {
+		return 2 // _ag_site and _ag_recv
+	}
+	return 1 // _ag_site
+}
+
 // _proxy_decl generates _ag_proxy_func decl like this:
-// `func _ag_proxy_0(s string)`
+// `func _ag_proxy_0(_ag_site *aspectrt.CallSite, s string)`
 func (r *rewriter) _proxy_decl(node ast.Node, matched types.Object, proxyName string) *ast.FuncDecl {
-	sig := matched.Type().(*types.Signature)
+	sig := r.signature(matched)
 	funcDecl := &ast.FuncDecl{}
 	funcDecl.Name = ast.NewIdent(proxyName)
 	funcDecl.Type = &ast.FuncType{}
 	params, results := &ast.FieldList{}, &ast.FieldList{}
 	params.List, results.List = make([]*ast.Field, 0), make([]*ast.Field, 0)
+	params.List = append(params.List, r.siteField())
 	if sig.Recv() != nil {
 		param := &ast.Field{
 			Names: []*ast.Ident{ast.NewIdent("_ag_recv")},
@@ -130,15 +578,15 @@ func (r *rewriter) _proxy_decl(node ast.Node, matched types.Object, proxyName st
 	for i := 0; i < sig.Params().Len(); i++ {
 		sigParam := sig.Params().At(i)
 		param := &ast.Field{}
-		param.Names = []*ast.Ident{ast.NewIdent(sigParam.Name())}
+		param.Names = []*ast.Ident{ast.NewIdent(r.currentParams[i])}
 		paramTypeStr := r.typeString(sigParam.Type())
 		param.Type = ast.NewIdent(paramTypeStr)
 		params.List = append(params.List, param)
 	}
+	// the results are not named, so that they do not shadow anything
 	for i := 0; i < sig.Results().Len(); i++ {
 		sigResult := sig.Results().At(i)
 		result := &ast.Field{}
-		result.Names = []*ast.Ident{ast.NewIdent(sigResult.Name())}
 		result.Type = ast.NewIdent(r.typeString(sigResult.Type()))
 		results.List = append(results.List, result)
 	}
@@ -149,16 +597,72 @@ func (r *rewriter) _proxy_decl(node ast.Node, matched types.Object, proxyName st
 // _proxy_body_XArgs generates like this:
 // `XArgs: []interface{}{"world"}`
 func (r *rewriter) _proxy_body_XArgs(matched types.Object) []ast.Expr {
-	sig := matched.Type().(*types.Signature)
+	sig := r.signature(matched)
 	var xArgsExprs []ast.Expr
 	for i := 0; i < sig.Params().Len(); i++ {
-		sigParam := sig.Params().At(i)
-		xArgsExprs = append(xArgsExprs, ast.NewIdent(sigParam.Name()))
+		xArgsExprs = append(xArgsExprs, ast.NewIdent(r.currentParams[i]))
 	}
 
 	return xArgsExprs
 }
 
+// isPackageLevel returns true if obj is declared in the package scope.
+func isPackageLevel(obj types.Object) bool {
+	return obj.Pkg() != nil && obj.Pkg().Scope().Lookup(obj.Name()) == obj
+}
+
+// funcExpr returns the expression for calling matched in the current file.
+// The package-level objects are qualified with fileImports, so that the
+// expression does not depend on the import names of the call site.
+// name is used for the other objects.
+// Generic functions are instantiated with r.currentInst.
+func (r *rewriter) funcExpr(matched types.Object, name string) ast.Expr {
+	if !isPackageLevel(matched) {
+		return ast.NewIdent(name)
+	}
+	var fun ast.Expr = ast.NewIdent(matched.Name())
+	if qual := r.fileImports.qualifier(matched.Pkg()); qual != "" {
+		fun = &ast.SelectorExpr{
+			X:   ast.NewIdent(qual),
+			Sel: ast.NewIdent(matched.Name())}
+	}
+	typeArgs := r.currentInst.TypeArgs
+	if typeArgs == nil || typeArgs.Len() == 0 {
+		return fun
+	}
+	// the type arguments are explicitly specified, as they cannot be
+	// inferred in some cases, e.g. `func New[T any]() T`
+	var indices []ast.Expr
+	for i := 0; i < typeArgs.Len(); i++ {
+		indices = append(indices, ast.NewIdent(r.typeString(typeArgs.At(i))))
+	}
+	return &ast.IndexListExpr{X: fun, Indices: indices}
+}
+
+// calleeExpr returns the expression for calling the callee in the proxy.
+// e.g. `sayHello`, `foo.SayHello` or `_ag_recv.SayHello`
+func (r *rewriter) calleeExpr(node ast.Node, matched types.Object) ast.Expr {
+	switch n := node.(type) {
+	case *ast.Ident:
+		return r.funcExpr(matched, n.Name)
+	case *ast.SelectorExpr:
+		if r.signature(matched).Recv() != nil {
+			return &ast.SelectorExpr{
+				X:   ast.NewIdent("_ag_recv"),
+				Sel: ast.NewIdent(n.Sel.Name)}
+		} else if isPackageLevel(matched) {
+			return r.funcExpr(matched, n.Sel.Name)
+		}
+		// FIXME: copy n.X
+		return &ast.SelectorExpr{
+			X:   n.X,
+			Sel: ast.NewIdent(n.Sel.Name)}
+	default:
+		r.fail(node, matched, ErrImpl, "%s is unexpected type", util.ASTDebugString(n))
+		return ast.NewIdent("_")
+	}
+}
+
 // _proxy_body_XFunc generates like this:
 // `XFunc: func(_ag_args []interface{}) []interface {} {
 //                _ag_arg0 := _ag_args[0].(string)
@@ -167,7 +671,7 @@ func (r *rewriter) _proxy_body_XArgs(matched types.Object) []ast.Expr {
 //                return _ag_res
 //          }`
 func (r *rewriter) _proxy_body_XFunc(node ast.Node, matched types.Object) *ast.FuncLit {
-	sig := matched.Type().(*types.Signature)
+	sig := r.signature(matched)
 	var xFuncBodyStmts []ast.Stmt
 	var xFuncBodyArgExprs []ast.Expr
 	for i := 0; i < sig.Params().Len(); i++ {
@@ -198,24 +702,7 @@ func (r *rewriter) _proxy_body_XFunc(node ast.Node, matched types.Object) *ast.F
 				}}}
 		xFuncBodyStmts = append(xFuncBodyStmts, assignStmt)
 	}
-	var xFuncBodyCallFuncExp ast.Expr
-	switch n := node.(type) {
-	case *ast.Ident:
-		xFuncBodyCallFuncExp = ast.NewIdent(n.Name)
-	case *ast.SelectorExpr:
-		var x ast.Expr
-		if sig.Recv() != nil {
-			x = ast.NewIdent("_ag_recv")
-		} else {
-			// FIXME FIXME FIXME: copy n.X
-			x = n.X
-		}
-		xFuncBodyCallFuncExp = &ast.SelectorExpr{
-			X:   x,
-			Sel: ast.NewIdent(n.Sel.Name)}
-	default:
-		log.Fatalf("impl error: %s is unexpected type", util.ASTDebugString(n))
-	}
+	xFuncBodyCallFuncExp := r.calleeExpr(node, matched)
 	var xFuncBodyCallLhs []ast.Expr
 	var xFuncBodyCallLhs2 []ast.Expr
 	for i := 0; i < sig.Results().Len(); i++ {
@@ -266,8 +753,70 @@ func (r *rewriter) _proxy_body_XFunc(node ast.Node, matched types.Object) *ast.F
 	return xFuncLit
 }
 
+// _proxy_body_XFuncOn generates XFunc with the receiver, for methods like this:
+// `XFuncOn: func(_ag_newrecv interface{}, _ag_args []interface{}) []interface {} {
+//                _ag_recv, _ag_ok := _ag_newrecv.(*S)
+//                if !_ag_ok {
+//                        panic(aspectrt.NewReceiverTypeError(_ag_site, "*example.com/foo.S", _ag_newrecv))
+//                }
+//                _ag_arg0 := _ag_args[0].(string)
+//                _ag_recv.SayHello(_ag_arg0)
+//                _ag_res := []interface{}{}
+//                return _ag_res
+//          }`
+func (r *rewriter) _proxy_body_XFuncOn(node ast.Node, matched types.Object) *ast.FuncLit {
+	recvType := r.signature(matched).Recv().Type()
+	funcLit := r._proxy_body_XFunc(node, matched)
+	funcLit.Type.Params.List = append([]*ast.Field{
+		&ast.Field{
+			Names: []*ast.Ident{ast.NewIdent("_ag_newrecv")},
+			Type:  &ast.InterfaceType{Methods: &ast.FieldList{}}},
+	}, funcLit.Type.Params.List...)
+	assertStmt := &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent("_ag_recv"), ast.NewIdent("_ag_ok")},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.TypeAssertExpr{
+				X:    ast.NewIdent("_ag_newrecv"),
+				Type: &ast.ParenExpr{X: ast.NewIdent(r.typeString(recvType))},
+			}}}
+	panicStmt := &ast.IfStmt{
+		Cond: &ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent("_ag_ok")},
+		Body: &ast.BlockStmt{List: []ast.Stmt{
+			&ast.ExprStmt{X: &ast.CallExpr{
+				Fun: ast.NewIdent("panic"),
+				Args: []ast.Expr{&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent(r.currentImportNames.rt),
+						Sel: ast.NewIdent("NewReceiverTypeError"),
+					},
+					Args: []ast.Expr{
+						ast.NewIdent("_ag_site"),
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(types.TypeString(recvType, nil))},
+						ast.NewIdent("_ag_newrecv"),
+					}}}}},
+		}}}
+	funcLit.Body.List = append([]ast.Stmt{assertStmt, panicStmt}, funcLit.Body.List...)
+	return funcLit
+}
+
+// _proxy_body_XResultTypes generates the nil pointers to the result types
+// like this: `(*int)(nil), (*error)(nil)`
+func (r *rewriter) _proxy_body_XResultTypes(results *types.Tuple) []ast.Expr {
+	var exprs []ast.Expr
+	for i := 0; i < results.Len(); i++ {
+		exprs = append(exprs, &ast.CallExpr{
+			Fun: &ast.ParenExpr{
+				X: &ast.StarExpr{X: ast.NewIdent(r.typeString(results.At(i).Type()))},
+			},
+			Args: []ast.Expr{ast.NewIdent("nil")},
+		})
+	}
+	return exprs
+}
+
 func (r *rewriter) _proxy_body_XReceiver(node ast.Node, matched types.Object) ast.Expr {
-	sig := matched.Type().(*types.Signature)
+	sig := r.signature(matched)
 	recv := sig.Recv()
 	if recv != nil {
 		return ast.NewIdent("_ag_recv")
@@ -275,17 +824,30 @@ func (r *rewriter) _proxy_body_XReceiver(node ast.Node, matched types.Object) as
 	return ast.NewIdent("nil")
 }
 
+// isGoContext reports whether typ is context.Context.
+func isGoContext(typ types.Type) bool {
+	named, ok := types.Unalias(typ).(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
 func (r *rewriter) _proxy_body_callExpr(node ast.Node, matched types.Object, asp *types.Named) *ast.CallExpr {
 	callExpr := &ast.CallExpr{}
+	instanceExpr := &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X: &ast.SelectorExpr{
+				X:   ast.NewIdent(r.currentImportNames.aspect),
+				Sel: ast.NewIdent(holderName(asp)),
+			},
+			Sel: ast.NewIdent("Instance"),
+		},
+		Args: []ast.Expr{r._proxy_body_XReceiver(node, matched)},
+	}
 	adviceExpr := &ast.SelectorExpr{
-		X: &ast.ParenExpr{
-			X: &ast.UnaryExpr{
-				Op: token.AND,
-				X: &ast.CompositeLit{
-					Type: &ast.SelectorExpr{
-						X:   ast.NewIdent("agaspect"),
-						Sel: ast.NewIdent(asp.Obj().Name()),
-					}}}},
+		X: instanceExpr,
 		Sel: &ast.Ident{
 			Name: "Advice",
 		}}
@@ -294,7 +856,7 @@ func (r *rewriter) _proxy_body_callExpr(node ast.Node, matched types.Object, asp
 		Op: token.AND,
 		X: &ast.CompositeLit{
 			Type: &ast.SelectorExpr{
-				X:   ast.NewIdent("aspectrt"),
+				X:   ast.NewIdent(r.currentImportNames.rt),
 				Sel: ast.NewIdent("ContextImpl"),
 			},
 			Elts: []ast.Expr{
@@ -311,16 +873,91 @@ func (r *rewriter) _proxy_body_callExpr(node ast.Node, matched types.Object, asp
 				&ast.KeyValueExpr{
 					Key:   ast.NewIdent("XReceiver"),
 					Value: r._proxy_body_XReceiver(node, matched),
+				},
+				&ast.KeyValueExpr{
+					Key:   ast.NewIdent("XCallSite"),
+					Value: ast.NewIdent("_ag_site"),
 				}}}}
+	if results := r.signature(matched).Results(); results.Len() > 0 {
+		lit := ctxExpr.X.(*ast.CompositeLit)
+		lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+			Key: ast.NewIdent("XResultTypes"),
+			Value: &ast.CompositeLit{
+				Type: voidIntfArrayExpr(),
+				Elts: r._proxy_body_XResultTypes(results),
+			}})
+	}
+	if r.signature(matched).Recv() != nil {
+		lit := ctxExpr.X.(*ast.CompositeLit)
+		lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+			Key:   ast.NewIdent("XFuncOn"),
+			Value: r._proxy_body_XFuncOn(node, matched),
+		})
+	}
+	if params := r.signature(matched).Params(); params.Len() > 0 && isGoContext(params.At(0).Type()) {
+		lit := ctxExpr.X.(*ast.CompositeLit)
+		lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+			Key:   ast.NewIdent("XGoContext"),
+			Value: ast.NewIdent("true"),
+		})
+	}
 
 	callExpr.Fun = adviceExpr
 	callExpr.Args = []ast.Expr{ctxExpr}
 	return callExpr
 }
 
+// _proxy_body_disabled generates the statement for calling the callee
+// directly when the aspect is disabled at runtime, like this:
+// `if !agaspect.XHolderExampleAspect.Enabled() { return sayHello(s) }`
+func (r *rewriter) _proxy_body_disabled(node ast.Node, matched types.Object, asp *types.Named) ast.Stmt {
+	sig := r.signature(matched)
+	var args []ast.Expr
+	for i := 0; i < sig.Params().Len(); i++ {
+		arg := r.currentParams[i]
+		if i == sig.Params().Len()-1 && sig.Variadic() {
+			arg += "..."
+		}
+		args = append(args, ast.NewIdent(arg))
+	}
+	callExpr := &ast.CallExpr{
+		Fun:  r.calleeExpr(node, matched),
+		Args: args,
+	}
+	var body []ast.Stmt
+	if sig.Results().Len() > 0 {
+		body = []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{callExpr}}}
+	} else {
+		body = []ast.Stmt{&ast.ExprStmt{X: callExpr}, &ast.ReturnStmt{}}
+	}
+	return &ast.IfStmt{
+		Cond: &ast.UnaryExpr{
+			Op: token.NOT,
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X: &ast.SelectorExpr{
+						X:   ast.NewIdent(r.currentImportNames.aspect),
+						Sel: ast.NewIdent(holderName(asp)),
+					},
+					Sel: ast.NewIdent("Enabled"),
+				}}},
+		Body: &ast.BlockStmt{List: body},
+	}
+}
+
+// uncheckedResults reports whether asp implements aspect.UncheckedResults.
+func uncheckedResults(asp *types.Named) bool {
+	mset := types.NewMethodSet(types.NewPointer(asp))
+	return mset.Lookup(asp.Obj().Pkg(), "UncheckedResults") != nil
+}
+
 // _proxy_body generates _ag_proxy_func body like this:
 //
-// _ag_res := (&dummyAspect{}).Advice(
+// if !agaspect.XHolderDummyAspect.Enabled() {
+// 	sayHello(s)
+// 	return
+// }
+// _ag_res := agaspect.XHolderDummyAspect.Instance(nil).Advice(
 // 	&ContextImpl{
 // 		XArgs: []interface{}{"world"},
 // 		XFunc: func(_ag_args []interface{}) []interface{} {
@@ -328,18 +965,51 @@ func (r *rewriter) _proxy_body_callExpr(node ast.Node, matched types.Object, asp
 // 			sayHello(_ag_arg0)
 // 			_ag_res := []interface{}{}
 // 			return _ag_res
-// 		}})
+// 		},
+// 		XCallSite: _ag_site})
 // _ = _ag_res
 // return
+//
+// If the callee has results, the context is assigned to _ag_ctx, and the
+// results of the advice are checked with
+// `_ag_res = aspectrt.CheckResults(_ag_ctx, "DummyAspect", _ag_res)`
+// unless the aspect implements aspect.UncheckedResults.
 func (r *rewriter) _proxy_body(node ast.Node, matched types.Object, asp *types.Named) *ast.BlockStmt {
 	var stmts []ast.Stmt
+	stmts = append(stmts, r._proxy_body_disabled(node, matched, asp))
+	sig := r.signature(matched)
+	callExpr := r._proxy_body_callExpr(node, matched, asp)
+	checked := sig.Results().Len() > 0 && !uncheckedResults(asp)
+	if checked {
+		stmts = append(stmts,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_ag_ctx")},
+				Tok: token.DEFINE,
+				Rhs: callExpr.Args})
+		callExpr.Args = []ast.Expr{ast.NewIdent("_ag_ctx")}
+	}
 	stmts = append(stmts,
 		&ast.AssignStmt{
 			Lhs: []ast.Expr{ast.NewIdent("_ag_res")},
 			Tok: token.DEFINE,
-			Rhs: []ast.Expr{r._proxy_body_callExpr(node, matched, asp)}})
+			Rhs: []ast.Expr{callExpr}})
+	if checked {
+		stmts = append(stmts,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_ag_res")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent(r.currentImportNames.rt),
+						Sel: ast.NewIdent("CheckResults"),
+					},
+					Args: []ast.Expr{
+						ast.NewIdent("_ag_ctx"),
+						&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(asp.Obj().Name())},
+						ast.NewIdent("_ag_res"),
+					}}}})
+	}
 
-	sig := matched.Type().(*types.Signature)
 	var resAssignStmts []ast.Stmt
 	var resExprs []ast.Expr
 	for i := 0; i < sig.Results().Len(); i++ {
@@ -372,23 +1042,70 @@ func (r *rewriter) _proxy_body(node ast.Node, matched types.Object, asp *types.N
 	return res
 }
 
+// paramNames returns the names of the parameters of the proxy for matched.
+// The source names are used if possible, but the names are synthesized like
+// "_ag_param0" when they are missing, blank, or collide with the identifiers
+// used in the proxy, e.g. the package names in the parameter types.
+func (r *rewriter) paramNames(matched types.Object) []string {
+	sig := r.signature(matched)
+	// the identifiers in the rendered types, including the added imports
+	var typs []string
+	if sig.Recv() != nil {
+		typs = append(typs, r.typeString(sig.Recv().Type()))
+	}
+	for _, tuple := range []*types.Tuple{sig.Params(), sig.Results()} {
+		for i := 0; i < tuple.Len(); i++ {
+			typs = append(typs, r.typeString(tuple.At(i).Type()))
+		}
+	}
+	used := map[string]bool{
+		r.currentImportNames.rt:     true,
+		r.currentImportNames.aspect: true,
+		matched.Name():              true,
+	}
+	if isPackageLevel(matched) {
+		used[r.fileImports.qualifier(matched.Pkg())] = true
+	}
+	for _, typ := range typs {
+		for _, id := range identRegexp.FindAllString(typ, -1) {
+			used[id] = true
+		}
+	}
+	names := make([]string, sig.Params().Len())
+	for i := range names {
+		name := sig.Params().At(i).Name()
+		if name == "" || name == "_" || used[name] ||
+			strings.HasPrefix(name, "_ag_") || types.Universe.Lookup(name) != nil {
+			name = fmt.Sprintf("_ag_param%d", i)
+		}
+		names[i] = name
+	}
+	return names
+}
+
+// identRegexp matches identifiers in the rendered types.
+var identRegexp = regexp.MustCompile(`[\pL_][\pL\pN_]*`)
+
 func (r *rewriter) _proxy(node ast.Node, matched types.Object, proxyName string, asp *types.Named) *ast.FuncDecl {
+	r.currentParams = r.paramNames(matched)
+	defer func() { r.currentParams = nil }()
 	funcDecl := r._proxy_decl(node, matched, proxyName)
 	funcDecl.Body = r._proxy_body(node, matched, asp)
 	return funcDecl
 }
 
 func (r *rewriter) _pgen_decl(matched types.Object, pdecl *ast.FuncDecl, pgenName string) *ast.FuncDecl {
-	sig := matched.Type().(*types.Signature)
+	sig := r.signature(matched)
 	receiver := sig.Recv()
 	funcDecl := &ast.FuncDecl{}
 	funcDecl.Name = ast.NewIdent(pgenName)
 	funcDecl.Type = &ast.FuncType{}
 	params, results := &ast.FieldList{}, &ast.FieldList{}
 	params.List, results.List = make([]*ast.Field, 0), make([]*ast.Field, 0)
+	params.List = append(params.List, r.siteField())
 
 	if receiver != nil {
-		pdeclRecv := pdecl.Type.Params.List[0]
+		pdeclRecv := pdecl.Type.Params.List[1]
 		name := pdeclRecv.Names[0].Name
 		typ := r.typeString(receiver.Type())
 		param := &ast.Field{
@@ -399,10 +1116,7 @@ func (r *rewriter) _pgen_decl(matched types.Object, pdecl *ast.FuncDecl, pgenNam
 	}
 
 	pdParamsL, pdResultsL := make([]*ast.Field, 0), make([]*ast.Field, 0)
-	pdParamScanBegin := 0
-	if receiver != nil {
-		pdParamScanBegin = 1
-	}
+	pdParamScanBegin := proxyExtraParams(sig)
 	for i := pdParamScanBegin; i < len(pdecl.Type.Params.List); i++ {
 		typIdent := pdecl.Type.Params.List[i].Type.(*ast.Ident)
 		typ := typIdent.Name
@@ -434,17 +1148,13 @@ func (r *rewriter) _pgen_decl(matched types.Object, pdecl *ast.FuncDecl, pgenNam
 }
 
 func (r *rewriter) _pgen_body(matched types.Object, pdecl *ast.FuncDecl) *ast.BlockStmt {
-	sig := matched.Type().(*types.Signature)
-	receiver := sig.Recv()
+	sig := r.signature(matched)
 
 	funcLit := &ast.FuncLit{}
 	funcLit.Type = &ast.FuncType{}
 	params, results := &ast.FieldList{}, &ast.FieldList{}
 	pdParamsL, pdResultsL := make([]*ast.Field, 0), make([]*ast.Field, 0)
-	pdParamScanBegin := 0
-	if receiver != nil {
-		pdParamScanBegin = 1
-	}
+	pdParamScanBegin := proxyExtraParams(sig)
 	for i := pdParamScanBegin; i < len(pdecl.Type.Params.List); i++ {
 		typIdent := pdecl.Type.Params.List[i].Type.(*ast.Ident)
 		typ := typIdent.Name
@@ -504,32 +1214,37 @@ func (r *rewriter) _pgen_body(matched types.Object, pdecl *ast.FuncDecl) *ast.Bl
 // pgen is like this:
 //
 // var f func(int)
-// f := (_ag_pgen_ag_proxy_0(i)) // orig: f := i.Foo
+// f := (_ag_pgen_0(_ag_site_0, i)) // orig: f := i.Foo
 // f(42)
 //
-// func _ag_pgen_ag_proxy_0(i I) func(int) {
-// 	return func(x int){_ag_proxy_0(i, x)}
+// func _ag_pgen_0(_ag_site *aspectrt.CallSite, i I) func(int) {
+// 	return func(x int){_ag_proxy_0(_ag_site, i, x)}
 // }
 // ​
-// func _ag_proxy_0(i I, x int) {
+// func _ag_proxy_0(_ag_site *aspectrt.CallSite, i I, x int) {
 //   ..
 // }
+//
+// As pgen returns a closure, `defer i.Foo(42)` and `go i.Foo(42)` keep the
+// semantics of Go: the receiver and the arguments are evaluated at the
+// statement, while the advice is executed when the call is actually executed.
 func (r *rewriter) _pgen(matched types.Object, pdecl *ast.FuncDecl, pgenName string) *ast.FuncDecl {
 	funcDecl := r._pgen_decl(matched, pdecl, pgenName)
 	funcDecl.Body = r._pgen_body(matched, pdecl)
 	return funcDecl
 }
 
-func (r *rewriter) _proxy_fix_up(node ast.Node, matched types.Object, pgenName string) ast.Expr {
-	sig := matched.Type().(*types.Signature)
-	var args []ast.Expr
+func (r *rewriter) _proxy_fix_up(node ast.Node, matched types.Object, pgenName, siteName string) ast.Expr {
+	sig := r.signature(matched)
+	args := []ast.Expr{&ast.Ident{NamePos: node.Pos(), Name: siteName}}
 	recv := sig.Recv()
 	if recv != nil {
 		_, recvIsPointer := recv.Type().Underlying().(*types.Pointer)
 
 		xs, ok := node.(*ast.SelectorExpr)
 		if !ok {
-			log.Fatalf("impl error: node=%s, recv=%s", util.ASTDebugString(node), recv)
+			r.fail(node, matched, ErrUnsupported, "method is not selected via selector expression (recv=%s)", recv)
+			return node.(ast.Expr)
 		}
 		typesInfo := r.Program.AllPackages[r.currentPkg]
 		xTypeInfo := typesInfo.Types[xs.X.(ast.Expr)]
@@ -537,22 +1252,33 @@ func (r *rewriter) _proxy_fix_up(node ast.Node, matched types.Object, pgenName s
 
 		// FIXME FIXME FIXME: copy xs.X
 		x := xs.X
+		// the receiver is evaluated when pgen is called, i.e. at the
+		// `defer` and `go` statements, as Go does for the method value.
 		var arg ast.Expr
-		if recvIsPointer && !xIsPointer {
+		switch {
+		case recvIsPointer && !xIsPointer:
 			arg = &ast.UnaryExpr{
 				Op: token.AND,
 				X:  x}
-		} else {
+		case !recvIsPointer && xIsPointer:
+			// the value is copied, e.g. `defer p.Foo()` for `func (T) Foo()`
+			arg = &ast.StarExpr{X: x}
+		default:
 			arg = x
 		}
 		args = append(args, arg)
 	}
+	// the positions are set for the //line directives
 	callExpr := &ast.CallExpr{
-		Fun:  ast.NewIdent(pgenName),
+		Fun: &ast.Ident{
+			NamePos: node.Pos(),
+			Name:    pgenName,
+		},
 		Args: args,
 	}
 	parenExpr := &ast.ParenExpr{
-		X: callExpr,
+		Lparen: node.Pos(),
+		X:      callExpr,
 	}
 	return parenExpr
 }
@@ -562,8 +1288,13 @@ func (r *rewriter) _proxy_fix_up(node ast.Node, matched types.Object, pgenName s
 //
 // How it works:
 //   Step 1: calls _proxy for generating _ag_proxy_N addendum
-//   Step 2: calls _pgen for generating _ag_pgen_ag_proxy_N addendum
-//   Step 3: calls _proxy_fix_up for generating the new node
+//   Step 2: calls _pgen for generating _ag_pgen_N addendum
+//   Step 3: generates _ag_site_N variable for the call site
+//   Step 4: calls _proxy_fix_up for generating the new node
+// Step 1 and Step 2 are skipped if the proxy for the callee has been already
+// generated in the package.
+// The proxies are generated in the dedicated file of the package, unless the
+// current file has build constraints.
 func (r *rewriter) proxy(node ast.Node, pointcut aspect.Pointcut) ast.Expr {
 	var id *ast.Ident
 	switch n := node.(type) {
@@ -572,64 +1303,257 @@ func (r *rewriter) proxy(node ast.Node, pointcut aspect.Pointcut) ast.Expr {
 	case *ast.SelectorExpr:
 		id = n.Sel
 	default:
-		log.Fatalf("impl error: %s is unexpected type", util.ASTDebugString(n))
+		r.fail(node, nil, ErrImpl, "%s is unexpected type", util.ASTDebugString(n))
+		return node.(ast.Expr)
 	}
-	// alreadyGen, ok := r.proxyExprs[id]
-	// if ok {
-	// 	return alreadyGen
-	// }
-
 	matched, ok := r.Matched[id]
 	if !ok {
-		log.Fatalf("impl error: obj not found for id %s", id)
+		r.fail(node, nil, ErrImpl, "obj not found for id %s", id)
+		return node.(ast.Expr)
 	}
 	asp, ok := r.Aspects[pointcut]
 	if !ok {
-		log.Fatalf("impl error: asp %s not found for pointcut %s", asp, pointcut)
+		r.fail(node, matched, ErrImpl, "aspect not found for pointcut %s", pointcut)
+		return node.(ast.Expr)
+	}
+
+	inst, _ := match.Instance(r.Program, id)
+	r.currentNode, r.currentObj, r.currentInst = node, matched, inst
+	defer func() { r.currentNode, r.currentObj, r.currentInst = nil, nil, types.Instance{} }()
+
+	sig := r.signature(matched)
+	if sig.TypeParams().Len() > 0 {
+		r.fail(node, matched, ErrUnsupported, "instance not found for the generic function")
+		return node.(ast.Expr)
+	}
+	if hasTypeParam(sig) || (sig.Recv() != nil && hasTypeParam(sig.Recv().Type())) {
+		// skipped by skipUnweavable()
+		r.fail(node, matched, ErrImpl, "the call instantiated with type parameters is not skipped")
+		return node.(ast.Expr)
+	}
+
+	filename := r.Program.Fset.Position(r.currentFile.Pos()).Filename
+	key := proxyKey{
+		pkg:  r.currentPkg,
+		obj:  matched,
+		inst: calleeName(matched, inst),
+		asp:  asp,
+		test: strings.HasSuffix(filename, "_test.go"),
+	}
+	if r.fileConstrained {
+		key.file = r.currentFile
+	}
+	names, ok := r.proxies[key]
+	if !ok {
+		var file string
+		if key.file != nil {
+			file = filepath.Base(filename)
+		}
+		names = r.proxyNamesFor(matched, asp, key.test, file)
+		addenda := &r.fileAddendum
+		if key.file == nil {
+			sf := r.sharedFileFor(key.test)
+			addenda = &sf.addenda
+			fileImports := r.fileImports
+			r.fileImports = sf.imports
+			defer func() { r.fileImports = fileImports }()
+		}
+		proxyAst := r._proxy(node, matched, names.proxy, asp)
+		*addenda = append(*addenda,
+			addendum{Node: proxyAst, Position: r.AdvicePositions[asp]})
+
+		pgenAst := r._pgen(matched, proxyAst, names.pgen)
+		*addenda = append(*addenda,
+			addendum{Node: pgenAst, Position: r.AdvicePositions[asp]})
+		r.proxies[key] = names
 	}
 
-	proxyName := fmt.Sprintf("_ag_proxy_%d", gRewriterLastP)
-	pgenName := fmt.Sprintf("_ag_pgen%s", proxyName)
-	gRewriterLastP++
+	siteName := r.siteName(node, matched)
+	r.fileSites = append(r.fileSites, r._site(node, matched, siteName, asp))
+	r.fileRewritten = true
+	return r._proxy_fix_up(node, matched, names.pgen, siteName)
+}
 
-	proxyAst := r._proxy(node, matched, proxyName, asp)
-	r.fileAddendum = append(r.fileAddendum, proxyAst)
+// _site generates the call-site variable like this:
+// `_ag_site_0 = aspectrt.RegisterCallSite("0", &aspectrt.CallSite{Callee: "fmt.Println", Position: "example.com/foo/main.go:12:2"}, "ExampleAspect")`
+func (r *rewriter) _site(node ast.Node, matched types.Object, siteName string, asp *types.Named) *ast.ValueSpec {
+	site := &ast.UnaryExpr{
+		Op: token.AND,
+		X: &ast.CompositeLit{
+			Type: &ast.SelectorExpr{
+				X:   ast.NewIdent(r.currentImportNames.rt),
+				Sel: ast.NewIdent("CallSite"),
+			},
+			Elts: []ast.Expr{
+				&ast.KeyValueExpr{
+					Key:   ast.NewIdent("Callee"),
+					Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(calleeName(matched, r.currentInst))},
+				},
+				&ast.KeyValueExpr{
+					Key:   ast.NewIdent("Position"),
+					Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(r.sitePosition(node))},
+				}}}}
+	return &ast.ValueSpec{
+		Names: []*ast.Ident{ast.NewIdent(siteName)},
+		Values: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent(r.currentImportNames.rt),
+					Sel: ast.NewIdent("RegisterCallSite"),
+				},
+				Args: []ast.Expr{
+					&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(strings.TrimPrefix(siteName, "_ag_site_"))},
+					site,
+					&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(asp.Obj().Name())},
+				}}}}
+}
+
+// fail records the error for node.
+// If node and obj are nil, r.currentNode and r.currentObj are used.
+// Only the first error is recorded.
+func (r *rewriter) fail(node ast.Node, obj types.Object, category ErrorCategory, format string, args ...interface{}) {
+	if r.err != nil {
+		return
+	}
+	if node == nil {
+		node = r.currentNode
+	}
+	if obj == nil {
+		obj = r.currentObj
+	}
+	var pos token.Pos
+	if node != nil {
+		pos = node.Pos()
+	}
+	r.err = newError(r.Program.Fset, pos, obj, category, format, args...)
+}
 
-	pgenAst := r._pgen(matched, proxyAst, pgenName)
-	r.fileAddendum = append(r.fileAddendum, pgenAst)
+// signature returns the signature of matched.
+// For a generic function, the signature instantiated with r.currentInst is returned.
+func (r *rewriter) signature(matched types.Object) *types.Signature {
+	if r.currentInst.Type != nil {
+		return r.currentInst.Type.(*types.Signature)
+	}
+	return matched.Type().(*types.Signature)
+}
+
+// hasTypeParam returns true if typ refers to a type parameter.
+// The receiver of a signature is not checked.
+func hasTypeParam(typ types.Type) bool {
+	switch t := typ.(type) {
+	case *types.TypeParam:
+		return true
+	case *types.Pointer:
+		return hasTypeParam(t.Elem())
+	case *types.Slice:
+		return hasTypeParam(t.Elem())
+	case *types.Array:
+		return hasTypeParam(t.Elem())
+	case *types.Chan:
+		return hasTypeParam(t.Elem())
+	case *types.Map:
+		return hasTypeParam(t.Key()) || hasTypeParam(t.Elem())
+	case *types.Signature:
+		return hasTypeParam(t.Params()) || hasTypeParam(t.Results())
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			if hasTypeParam(t.At(i).Type()) {
+				return true
+			}
+		}
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if hasTypeParam(t.Field(i).Type()) {
+				return true
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < t.NumExplicitMethods(); i++ {
+			if hasTypeParam(t.ExplicitMethod(i).Type()) {
+				return true
+			}
+		}
+		for i := 0; i < t.NumEmbeddeds(); i++ {
+			if hasTypeParam(t.EmbeddedType(i)) {
+				return true
+			}
+		}
+	case *types.Named:
+		// the underlying type is not checked, as it can be recursive
+		for i := 0; i < t.TypeArgs().Len(); i++ {
+			if hasTypeParam(t.TypeArgs().At(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	expr := r._proxy_fix_up(node, matched, pgenName)
-	r.proxyExprs[id] = expr
-	return expr
+// instantiatedPointcut returns the pointcut for the generic function x
+// instantiated explicitly like `Map[int, string]`.
+func (r *rewriter) instantiatedPointcut(x ast.Expr) (aspect.Pointcut, bool) {
+	var id *ast.Ident
+	switch n := x.(type) {
+	case *ast.Ident:
+		id = n
+	case *ast.SelectorExpr:
+		id = n.Sel
+	default:
+		return "", false
+	}
+	pointcut, ok := r.PointcutsByIdent[id]
+	return pointcut, ok
 }
 
 func (r *rewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter) {
+	if r.err != nil {
+		return node, nil
+	}
 	switch n := node.(type) {
 	case *ast.File:
-		r.fileAddendum = make([]ast.Node, 0)
+		r.fileAddendum = make([]addendum, 0)
+		r.fileSites = nil
+		r.fileRewritten = false
+		r.fileConstrained = hasBuildConstraints(r.Program.Fset.Position(n.Pos()).Filename, n)
+		r.currentImportNames = r.generatedImports()
+		r.fileImports = newFileImports(r.currentPkg, n, r.packageUsedNames(),
+			r.currentImportNames.rt, r.currentImportNames.aspect)
 		newImports := []*ast.ImportSpec{
 			&ast.ImportSpec{
-				Name: ast.NewIdent("aspectrt"),
+				Name: ast.NewIdent(r.currentImportNames.rt),
 				Path: &ast.BasicLit{
 					Kind:  token.STRING,
 					Value: "\"" + consts.AspectGoPackagePath + "/aspect/rt\"",
 				}},
 			&ast.ImportSpec{
+				Name: ast.NewIdent(r.currentImportNames.aspect),
 				Path: &ast.BasicLit{
 					Kind:  token.STRING,
-					Value: "\"agaspect\"",
+					Value: strconv.Quote(r.AspectPackage),
 				}},
 		}
+		// the new imports are located at the package clause line,
+		// for the //line directives
+		for _, imp := range newImports {
+			imp.Path.ValuePos = n.Package
+		}
 		newFile := &ast.File{}
-		newFile.Name = ast.NewIdent(n.Name.Name)
+		newFile.Package = n.Package
+		newFile.Name = &ast.Ident{
+			NamePos: n.Name.NamePos,
+			Name:    n.Name.Name,
+		}
 		newFile.Decls = append([]ast.Decl{
 			&ast.GenDecl{
-				Tok:   token.IMPORT,
-				Specs: []ast.Spec{newImports[0]}},
+				TokPos: n.Package,
+				Tok:    token.IMPORT,
+				Specs:  []ast.Spec{newImports[0]}},
 			&ast.GenDecl{
-				Tok:   token.IMPORT,
-				Specs: []ast.Spec{newImports[1]}},
+				TokPos: n.Package,
+				Tok:    token.IMPORT,
+				Specs:  []ast.Spec{newImports[1]}},
 		}, n.Decls...)
+		newFile.Comments = n.Comments
 		newFile.Scope = n.Scope
 		newFile.Imports = append(newImports, n.Imports...)
 		newFile.Unresolved = n.Unresolved
@@ -648,21 +1572,48 @@ func (r *rewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter) {
 		}
 		newExpr := r.proxy(n, pointcut)
 		return newExpr, nil
+	case *ast.IndexExpr:
+		pointcut, ok := r.instantiatedPointcut(n.X)
+		if !ok {
+			goto nop
+		}
+		// the type arguments are dropped, as the proxy is generated
+		// for the instance
+		newExpr := r.proxy(n.X, pointcut)
+		return newExpr, nil
+	case *ast.IndexListExpr:
+		pointcut, ok := r.instantiatedPointcut(n.X)
+		if !ok {
+			// the vendored gorewrite does not know IndexListExpr
+			n.X = rewrite.Rewrite(r, n.X).(ast.Expr)
+			for i, x := range n.Indices {
+				n.Indices[i] = rewrite.Rewrite(r, x).(ast.Expr)
+			}
+			return n, nil
+		}
+		// the type arguments are dropped, as the proxy is generated
+		// for the instance
+		newExpr := r.proxy(n.X, pointcut)
+		return newExpr, nil
 	}
 nop:
 	return node, r
 }
 
-func (r *rewriter) AddendumForASTFile() []ast.Node {
-	return r.fileAddendum
+func (r *rewriter) AddendumForASTFile() []addendum {
+	if len(r.fileSites) == 0 {
+		return r.fileAddendum
+	}
+	sites := addendum{
+		Node: &ast.GenDecl{
+			Tok:   token.VAR,
+			Specs: r.fileSites,
+		}}
+	return append([]addendum{sites}, r.fileAddendum...)
 }
 
+// typeString returns the string for typ in the current file.
+// The imports for the packages referred from typ are added if needed.
 func (r *rewriter) typeString(typ types.Type) string {
-	s, err := util.LocalTypeString(typ,
-		r.currentPkg,
-		r.currentFile.Imports)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return s
+	return types.TypeString(typ, r.fileImports.qualifier)
 }
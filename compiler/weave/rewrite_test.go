@@ -0,0 +1,38 @@
+package weave
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestHasBuildConstraints(t *testing.T) {
+	testCases := []struct {
+		filename string
+		header   string
+		expected bool
+	}{
+		{"foo.go", "", false},
+		{"foo_test.go", "", false},
+		{"foo_bar.go", "", false},
+		{"foo_linux.go", "", true},
+		{"foo_plan9.go", "", true},
+		{"foo_freebsd_arm64.go", "", true},
+		{"foo_arm64_test.go", "", true},
+		{"foo.go", "//go:build plan9\n\n", true},
+		{"foo.go", "//go:build freebsd && arm64\n\n", true},
+		{"foo.go", "//go:build !windows\n\n", true},
+		{"foo.go", "// +build integration\n\n", true},
+		{"foo.go", "// Package foo is not constrained.\n", false},
+	}
+	for _, tc := range testCases {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, tc.filename, tc.header+"package foo\n", parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hasBuildConstraints("/src/example.com/foo/"+tc.filename, file); got != tc.expected {
+			t.Fatalf("%s %q: expected %v, got %v", tc.filename, tc.header, tc.expected, got)
+		}
+	}
+}
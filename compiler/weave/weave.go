@@ -2,10 +2,13 @@
 package weave
 
 import (
+	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/token"
 	"go/types"
 	"log"
+	"sort"
 	"strings"
 
 	"golang.org/x/tools/go/loader"
@@ -17,40 +20,59 @@ import (
 )
 
 // Weave weaves aspect files to the target package and emit the woven files to wovenGOPATH.
-func Weave(wovenGOPATH string, target string, af *parse.AspectFile) ([]string, error) {
-	_, prog, err := loadTarget(target)
+// If tests is true, _test.go files (including the external test package) are woven as well.
+// Weave returns the written file names and the woven join points.
+func Weave(wovenGOPATH string, target string, af *parse.AspectFile, tests bool) ([]string, []JoinPoint, error) {
+	_, prog, err := loadTarget(target, tests)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	matched, pointcutsByIdent, err := findMatchedThings(prog, af.Pointcuts)
+	matched, pointcutsByIdent, overridden, err := findMatchedThings(prog, af)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	skipUnweavable(prog, matched, pointcutsByIdent, overridden)
+	if err := checkAccessible(prog, matched); err != nil {
+		return nil, nil, err
 	}
 	if util.DebugMode {
 		log.Printf("Found %d matches", len(matched))
 	}
 	if len(matched) != len(pointcutsByIdent) {
-		log.Fatal("impl error")
+		return nil, nil, &Error{Category: ErrImpl,
+			Message: fmt.Sprintf("len(matched)=%d, len(pointcutsByIdent)=%d", len(matched), len(pointcutsByIdent))}
 	}
 	if len(matched) == 0 {
-		return []string{}, nil
+		return []string{}, []JoinPoint{}, nil
 	}
 
-	rewrittenFnames1, err := rewriteAspectFile(wovenGOPATH, af)
+	aspectPkg, err := aspectPackage(af)
+	if err != nil {
+		return nil, nil, err
+	}
+	rewrittenFnames1, err := rewriteAspectFile(wovenGOPATH, af, aspectPkg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	aspects := pointcutMapToAspectMap(af.Pointcuts)
+	advicePositions := make(map[*types.Named]token.Position)
+	for asp := range af.Pointcuts {
+		advicePositions[asp] = af.AdvicePosition(asp)
 	}
 	rw := &rewriter{
 		Program:          prog,
 		Matched:          matched,
-		Aspects:          pointcutMapToAspectMap(af.Pointcuts),
+		Aspects:          aspects,
 		PointcutsByIdent: pointcutsByIdent,
+		AdvicePositions:  advicePositions,
+		AspectPackage:    aspectPkg,
 	}
 	rewrittenFnames2, err := rewriteProgram(wovenGOPATH, rw)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return append(rewrittenFnames1, rewrittenFnames2...), nil
+	jps := joinPoints(prog, matched, pointcutsByIdent, overridden, aspects)
+	return append(rewrittenFnames1, rewrittenFnames2...), jps, nil
 }
 
 func pointcutMapToAspectMap(pointcuts map[*types.Named]aspect.Pointcut) map[aspect.Pointcut]*types.Named {
@@ -65,17 +87,23 @@ func pointcutMapToAspectMap(pointcuts map[*types.Named]aspect.Pointcut) map[aspe
 	return aspects
 }
 
-func findMatchedThings(prog *loader.Program, pointcuts map[*types.Named]aspect.Pointcut) (map[*ast.Ident]types.Object, map[*ast.Ident]aspect.Pointcut, error) {
+// findMatchedThings returns the matched objects and the pointcuts for them.
+// If an object matches multiple pointcuts, the pointcut of the aspect defined last
+// in the aspect file is effective. The other pointcuts are returned as overridden ones.
+func findMatchedThings(prog *loader.Program, af *parse.AspectFile) (map[*ast.Ident]types.Object, map[*ast.Ident]aspect.Pointcut, map[*ast.Ident][]aspect.Pointcut, error) {
 	objs := make(map[*ast.Ident]types.Object)
 	pointcutsByIdent := make(map[*ast.Ident]aspect.Pointcut)
+	overridden := make(map[*ast.Ident][]aspect.Pointcut)
+	aspects := sortedAspects(af.Pointcuts)
 	for _, pkgInfo := range prog.InitialPackages() {
 		for id, obj := range pkgInfo.Uses {
 			posn := prog.Fset.Position(id.Pos())
 			if strings.HasSuffix(posn.Filename, "_aspect.go") {
 				continue
 			}
-			for _, pointcut := range pointcuts {
-				matched := match.ObjMatchPointcut(prog, id, obj, pointcut)
+			for _, asp := range aspects {
+				pointcut := af.Pointcuts[asp]
+				matched := match.ObjMatchPointcut(prog, id, obj, af.Matchers[asp])
 				if !matched {
 					continue
 				}
@@ -90,19 +118,163 @@ func findMatchedThings(prog *loader.Program, pointcuts map[*types.Named]aspect.P
 					log.Printf("OVERRIDE %s:%d:%d: %s, pointcut=%s vs old=%s",
 						posn.Filename, posn.Line, posn.Column,
 						obj, pointcut, xpt)
+					overridden[id] = append(overridden[id], xpt)
 				}
 				pointcutsByIdent[id] = pointcut
 			}
 		}
 	}
-	return objs, pointcutsByIdent, nil
+	return objs, pointcutsByIdent, overridden, nil
 }
 
-func loadTarget(target string) (*loader.Config, *loader.Program, error) {
+// skipUnweavable removes the join points that cannot be woven from the maps
+// returned by findMatchedThings(), with a warning.
+// Such a join point is a call instantiated with the type parameters of the
+// enclosing generic function, e.g. `Zero[T]()`, or a deferred call to a
+// function calling recover(), e.g. `defer handlePanic()`.
+func skipUnweavable(prog *loader.Program, matched map[*ast.Ident]types.Object,
+	pointcutsByIdent map[*ast.Ident]aspect.Pointcut, overridden map[*ast.Ident][]aspect.Pointcut) {
+	deferred := deferredCallees(prog)
+	for id, obj := range matched {
+		reason := unweavableReason(prog, id, obj, deferred[id])
+		if reason == "" {
+			continue
+		}
+		inst, _ := match.Instance(prog, id)
+		log.Printf("WARNING: %s: skipping %s: %s", prog.Fset.Position(id.Pos()), calleeName(obj, inst), reason)
+		delete(matched, id)
+		delete(pointcutsByIdent, id)
+		delete(overridden, id)
+	}
+}
+
+// unweavableReason returns the reason why the join point cannot be woven, or
+// an empty string if it can be woven.
+// deferred is true if id is the callee of a defer statement.
+func unweavableReason(prog *loader.Program, id *ast.Ident, obj types.Object, deferred bool) string {
+	sig, ok := obj.Type().(*types.Signature)
+	if !ok {
+		return ""
+	}
+	if inst, ok := match.Instance(prog, id); ok {
+		sig = inst.Type.(*types.Signature)
+	}
+	if hasTypeParam(sig) || (sig.Recv() != nil && hasTypeParam(sig.Recv().Type())) {
+		// TODO: generate generic proxies
+		return "the call instantiated with type parameters cannot be woven"
+	}
+	if deferred && callsRecover(prog, obj) {
+		// recover() stops panicking only if it is called directly by the
+		// deferred function, which would be the woven proxy.
+		return "the deferred call to the function calling recover() cannot be woven"
+	}
+	return ""
+}
+
+// deferredCallees returns the identifiers of the functions called by the
+// defer statements, e.g. `f` and `Method` of `defer f()` and
+// `defer x.Method()`.
+func deferredCallees(prog *loader.Program) map[*ast.Ident]bool {
+	callees := make(map[*ast.Ident]bool)
+	for _, pkgInfo := range prog.AllPackages {
+		for _, file := range pkgInfo.Files {
+			ast.Inspect(file, func(node ast.Node) bool {
+				if stmt, ok := node.(*ast.DeferStmt); ok {
+					if id := calleeIdent(stmt.Call.Fun); id != nil {
+						callees[id] = true
+					}
+				}
+				return true
+			})
+		}
+	}
+	return callees
+}
+
+// calleeIdent returns the identifier of the callee expression fun, or nil.
+func calleeIdent(fun ast.Expr) *ast.Ident {
+	switch f := fun.(type) {
+	case *ast.Ident:
+		return f
+	case *ast.SelectorExpr:
+		return f.Sel
+	case *ast.ParenExpr:
+		return calleeIdent(f.X)
+	case *ast.IndexExpr:
+		return calleeIdent(f.X)
+	case *ast.IndexListExpr:
+		return calleeIdent(f.X)
+	}
+	return nil
+}
+
+// callsRecover reports whether the body of the function obj calls the
+// builtin recover() directly, i.e. not in a function literal.
+// It returns false if the declaration of obj is not loaded.
+func callsRecover(prog *loader.Program, obj types.Object) bool {
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+	fn = fn.Origin()
+	pkgInfo := prog.AllPackages[fn.Pkg()]
+	if pkgInfo == nil {
+		return false
+	}
+	for _, file := range pkgInfo.Files {
+		if file.Pos() > fn.Pos() || fn.Pos() > file.End() {
+			continue
+		}
+		for _, decl := range file.Decls {
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Name.Pos() != fn.Pos() || fd.Body == nil {
+				continue
+			}
+			found := false
+			ast.Inspect(fd.Body, func(node ast.Node) bool {
+				switch n := node.(type) {
+				case *ast.FuncLit:
+					return false
+				case *ast.CallExpr:
+					if id, ok := ast.Unparen(n.Fun).(*ast.Ident); ok {
+						if b, ok := pkgInfo.Uses[id].(*types.Builtin); ok && b.Name() == "recover" {
+							found = true
+						}
+					}
+				}
+				return !found
+			})
+			return found
+		}
+	}
+	return false
+}
+
+// sortedAspects returns the aspects sorted by the position in the aspect file.
+func sortedAspects(pointcuts map[*types.Named]aspect.Pointcut) []*types.Named {
+	var aspects []*types.Named
+	for asp := range pointcuts {
+		aspects = append(aspects, asp)
+	}
+	sort.Sort(aspectsByPos(aspects))
+	return aspects
+}
+
+type aspectsByPos []*types.Named
+
+func (s aspectsByPos) Len() int           { return len(s) }
+func (s aspectsByPos) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s aspectsByPos) Less(i, j int) bool { return s[i].Obj().Pos() < s[j].Obj().Pos() }
+
+func loadTarget(target string, tests bool) (*loader.Config, *loader.Program, error) {
 	conf := loader.Config{
 		ParserMode: parser.ParseComments,
 	}
-	conf.Import(target)
+	if tests {
+		conf.ImportWithTests(target)
+	} else {
+		conf.Import(target)
+	}
 	prog, err := conf.Load()
 	if err != nil {
 		return nil, nil, err
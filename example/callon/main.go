@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/AkihiroSuda/aspectgo/example/callon/store"
+)
+
+func main() {
+	s := &store.Store{Name: "production"}
+	fmt.Println(s.Get("foo"))
+}
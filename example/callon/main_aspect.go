@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/example/callon/store"
+)
+
+// MockAspect delegates the calls to the mock store.
+type MockAspect struct {
+}
+
+// Executed on compilation-time
+func (a *MockAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/callon/store")
+	s := `\(\*` + pkg + `\.Store\)\.Get$`
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *MockAspect) Advice(ctx asp.Context) []interface{} {
+	func() {
+		defer func() {
+			fmt.Println("ERROR", recover())
+		}()
+		ctx.CallOn(store.Store{Name: "not a pointer"}, ctx.Args())
+	}()
+	return ctx.CallOn(&store.Store{Name: "mock"}, ctx.Args())
+}
@@ -0,0 +1,12 @@
+// Package store is the dependency of the callon example.
+package store
+
+// Store is a key-value store.
+type Store struct {
+	Name string
+}
+
+// Get returns the value for key.
+func (s *Store) Get(key string) string {
+	return s.Name + ":" + key
+}
@@ -0,0 +1,6 @@
+package main
+
+func greetAll() {
+	sayHello("alice")
+	sayHello("bob")
+}
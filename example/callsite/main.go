@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+)
+
+func sayHello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func main() {
+	greetAll()
+	sayHello("world")
+}
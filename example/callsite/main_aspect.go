@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/callsite")
+	s := pkg + regexp.QuoteMeta(".sayHello")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	// all the call sites share the single proxy,
+	// but each of them has the distinct CallSite
+	fmt.Printf("ADVICE %s\n", ctx.CallSite())
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+func answer() (int, error) {
+	return 42, nil
+}
+
+func fastAnswer() int {
+	return 42
+}
+
+type T struct {
+	id int
+}
+
+func find(id int) (*T, error) {
+	return &T{id: id}, nil
+}
+
+func main() {
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				fmt.Println("recovered:", err)
+			}
+		}()
+		fmt.Println(answer())
+	}()
+	fmt.Println(fastAnswer())
+	fmt.Println(find(1))
+
+	aspect.SetResultErrorHandler(func(err *aspect.ResultError) {
+		fmt.Println("handled:", err.Reason)
+	})
+	fmt.Println(answer())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+var pkg = regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/checkresults")
+
+// AnswerAspect returns a wrongly typed result.
+// The woven code panics with *asp.ResultError, unless the handler is set
+// with asp.SetResultErrorHandler().
+type AnswerAspect struct {
+}
+
+func (a *AnswerAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.answer$`)
+}
+
+func (a *AnswerAspect) Advice(ctx asp.Context) []interface{} {
+	// should be int rather than int64
+	return []interface{}{int64(43), nil}
+}
+
+// FindAspect short-circuits the call with the untyped nil for *T, which
+// passes the check.
+type FindAspect struct {
+}
+
+func (a *FindAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.find$`)
+}
+
+func (a *FindAspect) Advice(ctx asp.Context) []interface{} {
+	return []interface{}{nil, errors.New("skip")}
+}
+
+// FastAnswerAspect returns a wrongly typed result as well, but the result
+// is not checked, and replaced with the zero value.
+type FastAnswerAspect struct {
+}
+
+func (a *FastAnswerAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.fastAnswer$`)
+}
+
+func (a *FastAnswerAspect) Advice(ctx asp.Context) []interface{} {
+	return []interface{}{int64(43)}
+}
+
+// UncheckedResults implements asp.UncheckedResults.
+func (a *FastAnswerAspect) UncheckedResults() {
+}
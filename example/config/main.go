@@ -0,0 +1,22 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func hello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func open(name string) error {
+	if name == "" {
+		return errors.New("empty name")
+	}
+	return nil
+}
+
+func main() {
+	hello("world")
+	fmt.Println("open:", open("foo"))
+}
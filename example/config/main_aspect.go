@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+var pkg = regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/config")
+
+// TraceAspect traces the calls to hello().
+type TraceAspect struct {
+}
+
+func (a *TraceAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.hello$`)
+}
+
+func (a *TraceAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("TRACE %v\n", ctx.Args())
+	return ctx.Call(ctx.Args())
+}
+
+// FaultAspect makes open() fail with the configured error message.
+type FaultAspect struct {
+	message string
+}
+
+// Configure implements asp.Configurable.
+func (a *FaultAspect) Configure(config map[string]string) {
+	a.message = config["message"]
+	if a.message == "" {
+		a.message = "injected fault"
+	}
+}
+
+func (a *FaultAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.open$`)
+}
+
+func (a *FaultAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.ReturnError(errors.New(a.message))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Counter struct {
+	N int
+}
+
+func (c Counter) Show(label string) {
+	fmt.Printf("%s: value receiver N=%d\n", label, c.N)
+}
+
+func (c *Counter) ShowPtr(label string) {
+	fmt.Printf("%s: pointer receiver N=%d\n", label, c.N)
+}
+
+func show(label string, x int) {
+	fmt.Printf("%s: x=%d\n", label, x)
+}
+
+func worker(wg *sync.WaitGroup, label string, x int) {
+	defer wg.Done()
+	show(label, x)
+}
+
+// deferred checks that the arguments and the receivers are evaluated
+// at the defer statement, while the calls are executed on return.
+func deferred() {
+	x := 1
+	c := Counter{N: 1}
+	p := &Counter{N: 1}
+	defer show("defer", x)
+	defer c.Show("defer")
+	// *p is copied at the defer statement
+	defer p.Show("defer")
+	// p is evaluated at the defer statement, but p.N is read on return
+	defer p.ShowPtr("defer")
+	x, c.N, p.N = 2, 2, 2
+	fmt.Println("returning")
+}
+
+func handlePanic(label string) {
+	if r := recover(); r != nil {
+		fmt.Printf("%s: recovered %v\n", label, r)
+	}
+}
+
+// recovered checks that the deferred call to the function calling recover()
+// is not woven, so that the panic is still recovered.
+func recovered() {
+	defer handlePanic("recover")
+	panic("boom")
+}
+
+// goroutine checks that the arguments are evaluated at the go statement.
+func goroutine() {
+	var wg sync.WaitGroup
+	x := 1
+	wg.Add(1)
+	go worker(&wg, "go", x)
+	x = 2
+	wg.Wait()
+}
+
+func main() {
+	deferred()
+	recovered()
+	// not deferred, so woven
+	handlePanic("direct")
+	goroutine()
+}
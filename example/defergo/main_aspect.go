@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/defergo")
+	s := pkg + `.*\.(show|Show|ShowPtr|worker|handlePanic)$`
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+// For `defer` and `go` statements, the advice is executed when the call
+// is actually executed, not at the statement.
+// The deferred call to handlePanic() is not woven, as it calls recover().
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("ADVICE %s\n", ctx.CallSite().Callee)
+	return ctx.Call(ctx.Args())
+}
@@ -1,15 +1,21 @@
 package example
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/AkihiroSuda/aspectgo/compiler"
 	agcli "github.com/AkihiroSuda/aspectgo/compiler/cli"
+	"github.com/AkihiroSuda/aspectgo/compiler/weave"
 )
 
 var (
@@ -29,13 +35,14 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func execAspectGo(t *testing.T, wovenGOPATH, pkg, aspectFileBasename string, recursive bool) error {
+func execAspectGo(t *testing.T, wovenGOPATH, pkg, aspectFileBasename string, recursive bool, extraArgs ...string) error {
 	pkgDir := filepath.Join(GOPATH, filepath.Join("src", pkg))
 	aspectFilename := filepath.Join(pkgDir, aspectFileBasename)
 	if recursive {
 		pkg += "/..."
 	}
 	args := []string{"aspectgo", "-w", wovenGOPATH, "-t", pkg}
+	args = append(args, extraArgs...)
 	if testing.Verbose() {
 		args = append(args, "-debug=true")
 	}
@@ -54,13 +61,42 @@ func execMainWithGOPATH(t *testing.T, gopath, pkg, mainFileBasename string) ([]b
 	}
 	pkgDir := filepath.Join(gopath, filepath.Join("src", pkg))
 	mainFilename := filepath.Join(pkgDir, mainFileBasename)
-	cmd := exec.Command("go", []string{"run", mainFilename}...)
-	cmd.Env = append([]string{fmt.Sprintf("GOPATH=%s", gopath)}, os.Environ()...)
+	// the woven package contains the file of the shared proxies, which
+	// needs to be listed for running the files rather than the package
+	matches, err := filepath.Glob(filepath.Join(pkgDir, "zz_aspectgo_proxies*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var proxies []string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, "_test.go") {
+			proxies = append(proxies, m)
+		}
+	}
+	cmd := exec.Command("go", append([]string{"run", mainFilename}, proxies...)...)
+	cmd.Env = envWithGOPATH(gopath)
+	out, err := cmd.CombinedOutput()
+	t.Logf("Test Result (GOPATH=%s):\n%s", gopath, string(out))
+	return out, err
+}
+
+func execGoTestWithGOPATH(t *testing.T, gopath, pkg string) ([]byte, error) {
+	if gopath == "" {
+		gopath = GOPATH
+	}
+	cmd := exec.Command("go", []string{"test", "-v", pkg}...)
+	cmd.Env = envWithGOPATH(gopath)
 	out, err := cmd.CombinedOutput()
 	t.Logf("Test Result (GOPATH=%s):\n%s", gopath, string(out))
 	return out, err
 }
 
+// envWithGOPATH returns os.Environ() with GOPATH overridden.
+func envWithGOPATH(gopath string) []string {
+	// the later one takes precedence
+	return append(os.Environ(), fmt.Sprintf("GOPATH=%s", gopath))
+}
+
 // textEx returns the output of the original test and the woven test suite if succeeds.
 // the output contains stderr.
 // if the woven test or aspectgo itself fails, testEx panics.
@@ -96,6 +132,40 @@ func testEx(t *testing.T, dirname, mainFileBasename, aspectFileBasename string,
 	return out1, out2
 }
 
+// testExGoTest is similar to testEx, but executes `go test` instead of `go run`.
+// The aspect is woven to _test.go files as well.
+func testExGoTest(t *testing.T, dirname, aspectFileBasename string) ([]byte, []byte) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, dirname)
+	out1, err := execGoTestWithGOPATH(t, "", pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = execAspectGo(t, wovenGOPATH, pkg, aspectFileBasename, false, "-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out2, err := execGoTestWithGOPATH(t, wovenGOPATH, pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testing.Verbose() {
+		t.Logf("Keeping woven directory %s", wovenGOPATH)
+	} else {
+		os.RemoveAll(wovenGOPATH)
+	}
+
+	return out1, out2
+}
+
 func TestExHello(t *testing.T) {
 	testEx(t, "hello", "main.go", "main_aspect.go", false)
 }
@@ -124,6 +194,785 @@ func TestExDetreplay(t *testing.T) {
 	testEx(t, "detreplay", "main.go", "main_aspect.go", false)
 }
 
+func TestExLineinfo(t *testing.T) {
+	out1, out2 := testEx(t, "lineinfo", "main.go", "main_aspect.go", false)
+	var advice, rest [][]byte
+	for _, l := range bytes.SplitAfter(out2, []byte("\n")) {
+		if bytes.HasPrefix(l, []byte("ADVICE")) {
+			advice = append(advice, l)
+		} else {
+			rest = append(rest, l)
+		}
+	}
+	// the positions in the woven binary should be mapped to the original source
+	if !bytes.Equal(out1, bytes.Join(rest, nil)) {
+		t.Fatalf("positions mismatch: %q vs %q", out1, rest)
+	}
+	// the proxy should be mapped to the aspect file
+	if len(advice) != 1 || !bytes.Contains(advice[0], []byte("lineinfo/main_aspect.go")) {
+		t.Fatalf("unexpected advice output: %q", advice)
+	}
+}
+
+// TestExCallsite checks that the call sites of the same callee share
+// the single proxy, and that the advice can distinguish the call sites.
+func TestExCallsite(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "callsite")
+	wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wovenGOPATH)
+	if err = execAspectGo(t, wovenGOPATH, pkg, "main_aspect.go", false); err != nil {
+		t.Fatal(err)
+	}
+	// the shared proxies are in the dedicated file
+	files, err := filepath.Glob(filepath.Join(wovenGOPATH, "src", pkg, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies := 0
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxies += bytes.Count(b, []byte("func _ag_proxy_"))
+	}
+	if proxies != 1 {
+		t.Fatalf("expected 1 proxy, got %d", proxies)
+	}
+	// the package consists of multiple files
+	cmd := exec.Command("go", "run", pkg)
+	cmd.Env = envWithGOPATH(wovenGOPATH)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	for _, s := range []string{
+		pkg + "/greet.go:4:2 (" + pkg + ".sayHello)",
+		pkg + "/greet.go:5:2 (" + pkg + ".sayHello)",
+		pkg + "/main.go:13:2 (" + pkg + ".sayHello)",
+	} {
+		if !bytes.Contains(out, []byte("ADVICE "+s+"\n")) {
+			t.Fatalf("call site %s not found in the output:\n%s", s, out)
+		}
+	}
+}
+
+// TestExMultifile checks that the files calling the shared proxy build even
+// if they import the package only for the woven call, and that the proxies
+// for a file with build constraints do not break the other builds.
+func TestExMultifile(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "multifile")
+	wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wovenGOPATH)
+	if err = execAspectGo(t, wovenGOPATH, pkg, "main_aspect.go", false); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", pkg)
+	cmd.Env = envWithGOPATH(wovenGOPATH)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	platformFile := "a_linux.go"
+	if runtime.GOOS != "linux" {
+		platformFile = "a_other.go"
+	}
+	expected := "ADVICE " + pkg + "/main.go:9:2 (fmt.Println)\nmain\n" +
+		"ADVICE " + pkg + "/greet.go:8:2 (fmt.Println)\nhello\n" +
+		"ADVICE " + pkg + "/" + platformFile + ":10:2 (fmt.Println)\nplatform\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	// the file with build constraints for the other GOOS is not woven
+	otherGOOS := "windows"
+	if runtime.GOOS == otherGOOS {
+		otherGOOS = "linux"
+	}
+	cmd = exec.Command("go", "build", "-o", os.DevNull, pkg)
+	cmd.Env = append(envWithGOPATH(wovenGOPATH), "GOOS="+otherGOOS)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("GOOS=%s: %v: %s", otherGOOS, err, out)
+	}
+}
+
+func TestExTypeimports(t *testing.T) {
+	out1, out2 := testEx(t, "typeimports", "main.go", "main_aspect.go", false)
+	if !bytes.Equal(append([]byte("BEFORE hello\n"), out1...), out2) {
+		t.Fatalf("unexpected output: %q", out2)
+	}
+}
+
+func TestExImportnames(t *testing.T) {
+	out1, out2 := testEx(t, "importnames", "main.go", "main_aspect.go", false)
+	expected := "BEFORE hello\nhello aspectrt\nBEFORE hello\nhello agaspect\n"
+	if string(out1) != "hello aspectrt\nhello agaspect\n" || string(out2) != expected {
+		t.Fatalf("unexpected output: %q, %q", out1, out2)
+	}
+}
+
+func TestExInstantiation(t *testing.T) {
+	_, out := testEx(t, "instantiation", "main.go", "main_aspect.go", false)
+	expected := `singleton 1
+hello
+singleton 2
+hello
+perthis 1
+inc a
+perthis 1
+inc b
+perthis 2
+inc a
+percall 1
+world
+percall 1
+world
+pergoroutine 1
+greet
+pergoroutine 1
+greet
+pergoroutine 2
+greet
+pergoroutine 2
+greet
+`
+	if string(out) != expected {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExSwitch(t *testing.T) {
+	_, out := testEx(t, "switch", "main.go", "main_aspect.go", false)
+	expected := "BEFORE hello\nhello enabled\nhello disabled\nBEFORE hello\nhello enabled again\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExJoinpoints(t *testing.T) {
+	out1, out2 := testEx(t, "joinpoints", "main.go", "main_aspect.go", false)
+	if !bytes.HasSuffix(out1, []byte("\n0 join point(s)\n")) {
+		t.Fatalf("unexpected output: %q", out1)
+	}
+	pkg := filepath.Join(exPackage, "joinpoints")
+	for _, s := range []string{
+		pkg + "/main.go:15:2: " + pkg + ".sayHello (ExampleAspect) [",
+		pkg + "/main.go:16:2: " + pkg + ".sayHello (ExampleAspect) [",
+		"\n2 join point(s)\n",
+	} {
+		if !bytes.Contains(out2, []byte(s)) {
+			t.Fatalf("%q not found in the output: %q", s, out2)
+		}
+	}
+	// the positions are the same as `aspectgo plan`, except for the file
+	// names prefixed with the import path
+	comp := compiler.Compiler{
+		Target:          pkg,
+		AspectFilenames: []string{filepath.Join(GOPATH, "src", pkg, "main_aspect.go")},
+	}
+	jps, err := comp.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, jp := range jps {
+		s := fmt.Sprintf("%s/%s:%d:%d: %s (%s) [", pkg, filepath.Base(jp.Position.Filename),
+			jp.Position.Line, jp.Position.Column, jp.Callee, jp.Aspect)
+		if !bytes.Contains(out2, []byte(s)) {
+			t.Fatalf("%q not found in the output: %q", s, out2)
+		}
+	}
+}
+
+func TestExGocontext(t *testing.T) {
+	out1, out2 := testEx(t, "gocontext", "main.go", "main_aspect.go", false)
+	if string(out1) != "foo (without deadline)\n" {
+		t.Fatalf("unexpected output: %q", out1)
+	}
+	if string(out2) != "ADVICE context.Background\nfoo (with deadline)\n" {
+		t.Fatalf("unexpected output: %q", out2)
+	}
+}
+
+func TestExCallon(t *testing.T) {
+	out1, out2 := testEx(t, "callon", "main.go", "main_aspect.go", false)
+	if string(out1) != "production:foo\n" {
+		t.Fatalf("unexpected output: %q", out1)
+	}
+	typ := "*" + exPackage + "/callon/store.Store"
+	expected := "ERROR cannot call (" + typ + ").Get on a receiver of type store.Store: expected " + typ + "\n" +
+		"mock:foo\n"
+	if string(out2) != expected {
+		t.Fatalf("unexpected output: %q", out2)
+	}
+}
+
+func TestExSkipcall(t *testing.T) {
+	_, out := testEx(t, "skipcall", "main.go", "main_aspect.go", false)
+	expected := "2 <nil>\n0 division by zero\n1s true\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExCheckresults(t *testing.T) {
+	out1, out2 := testEx(t, "checkresults", "main.go", "main_aspect.go", false)
+	expected1 := "42 <nil>\n42\n&{1} <nil>\n42 <nil>\n"
+	if string(out1) != expected1 {
+		t.Fatalf("unexpected output: %q", out1)
+	}
+	expected2 := "recovered: invalid results of AnswerAspect for " +
+		filepath.Join(exPackage, "checkresults") + ".answer: " +
+		"expected (int, error), got [43 <nil>]: result 0 is int64\n0\n" +
+		"<nil> skip\n" +
+		"handled: result 0 is int64\n0 <nil>\n"
+	if string(out2) != expected2 {
+		t.Fatalf("unexpected output: %q", out2)
+	}
+}
+
+func TestExLogging(t *testing.T) {
+	_, out := testEx(t, "logging", "main.go", "main_aspect.go", false)
+	pkg := filepath.Join(exPackage, "logging")
+	site := func(line, callee string) string {
+		return pkg + "/main.go:" + line + " (" + pkg + "." + callee + ")"
+	}
+	expected := "ENTRY " + site("23:14", "div") + " args=[6 3]\n" +
+		"EXIT " + site("23:14", "div") + " args=[6 3] results=[2 <nil>]\n" +
+		"2 <nil>\n" +
+		"ENTRY " + site("24:14", "div") + " args=[6 0]\n" +
+		"EXIT " + site("24:14", "div") + " args=[6 0] results=[0 division by zero] err=\"division by zero\"\n" +
+		"0 division by zero\n" +
+		"ENTRY " + site("29:3", "mustPositive") + " args=[-1]\n" +
+		"PANIC " + site("29:3", "mustPositive") + " args=[-1] panic=\"not positive\"\n"
+	// the stack trace of the panic follows the record
+	stack, ok := strings.CutPrefix(string(out), expected)
+	if !ok {
+		t.Fatalf("unexpected output: %q", out)
+	}
+	stack, ok = strings.CutSuffix(stack, "recovered: not positive\n")
+	if !ok || !strings.HasPrefix(stack, "goroutine ") ||
+		!strings.Contains(stack, "main.mustPositive(") {
+		t.Fatalf("unexpected stack trace: %q", stack)
+	}
+}
+
+func TestExFault(t *testing.T) {
+	_, out := testEx(t, "fault", "main.go", "main_aspect.go", false)
+	expected := "content of foo <nil>\n disk full\n disk full\n" +
+		"42\n-1\n42\n" +
+		"recovered: injected fault\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExGenerics(t *testing.T) {
+	out1, out2 := testEx(t, "generics", "main.go", "main_aspect.go", false)
+	pkg := filepath.Join(exPackage, "generics")
+	expected := "ADVICE " + pkg + ".Sum[int]\n" +
+		"6\n" +
+		"4\n" +
+		"ADVICE " + pkg + ".Zero[string]\n" +
+		"\"\"\n" +
+		"ADVICE (*" + pkg + ".Stack[int]).Push\n" +
+		"1\n" +
+		"ADVICE " + pkg + ".ZeroOf[int]\n" +
+		"0\n" +
+		"{answer 42}\n"
+	if string(out2) != expected {
+		t.Fatalf("unexpected output: %q (original: %q)", out2, out1)
+	}
+}
+
+// TestExGenericsPlan checks that the call instantiated with type parameters
+// is skipped rather than failing the whole weave.
+func TestExGenericsPlan(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "generics")
+	comp := compiler.Compiler{
+		Target:          pkg,
+		AspectFilenames: []string{filepath.Join(GOPATH, "src", pkg, "main_aspect.go")},
+	}
+	jps, err := comp.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var callees []string
+	for _, jp := range jps {
+		callees = append(callees, jp.Callee)
+	}
+	expected := []string{
+		pkg + ".Sum[int]",
+		pkg + ".Zero[string]",
+		"(*" + pkg + ".Stack[int]).Push",
+		pkg + ".ZeroOf[int]",
+	}
+	if strings.Join(callees, ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected join points: %v", callees)
+	}
+}
+
+func TestExParams(t *testing.T) {
+	out1, out2 := testEx(t, "params", "main.go", "main_aspect.go", false)
+	var advice, rest [][]byte
+	for _, l := range bytes.SplitAfter(out2, []byte("\n")) {
+		if bytes.HasPrefix(l, []byte("ADVICE")) {
+			advice = append(advice, l)
+		} else {
+			rest = append(rest, l)
+		}
+	}
+	if !bytes.Equal(out1, bytes.Join(rest, nil)) {
+		t.Fatalf("output mismatch: %q vs %q", out1, rest)
+	}
+	pkg := filepath.Join(exPackage, "params")
+	expected := []string{
+		"ADVICE " + pkg + ".describe [1 a]\n",
+		"ADVICE " + pkg + ".ignore [2]\n",
+		"ADVICE " + pkg + ".fail []\n",
+	}
+	if len(advice) != 4 {
+		t.Fatalf("unexpected advice output: %q", advice)
+	}
+	for i, s := range expected {
+		if string(advice[i]) != s {
+			t.Fatalf("expected %q, got %q", s, advice[i])
+		}
+	}
+}
+
+func TestExDefergo(t *testing.T) {
+	out1, out2 := testEx(t, "defergo", "main.go", "main_aspect.go", false)
+	pkg := filepath.Join(exPackage, "defergo")
+	// the advice is executed when the deferred call is executed,
+	// with the receivers and the arguments evaluated at the defer statement.
+	// the deferred call to handlePanic() is not woven, but the direct call is.
+	expected := "returning\n" +
+		"ADVICE (*" + pkg + ".Counter).ShowPtr\n" +
+		"defer: pointer receiver N=2\n" +
+		"ADVICE (" + pkg + ".Counter).Show\n" +
+		"defer: value receiver N=1\n" +
+		"ADVICE (" + pkg + ".Counter).Show\n" +
+		"defer: value receiver N=1\n" +
+		"ADVICE " + pkg + ".show\n" +
+		"defer: x=1\n" +
+		"recover: recovered boom\n" +
+		"ADVICE " + pkg + ".handlePanic\n" +
+		"ADVICE " + pkg + ".worker\n" +
+		"ADVICE " + pkg + ".show\n" +
+		"go: x=1\n"
+	if string(out2) != expected {
+		t.Fatalf("unexpected output: %q (original: %q)", out2, out1)
+	}
+}
+
+// TestExWeavedeps checks that the calls inside the vendored package and
+// the GOROOT package are woven with -weave-deps.
+func TestExWeavedeps(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "weavedeps")
+	aspectFilename := filepath.Join(GOPATH, "src", pkg, "main_aspect.go")
+	outDir, err := ioutil.TempDir("", "agtestbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	bin := filepath.Join(outDir, "weavedeps")
+	args := []string{"aspectgo", "build", "-a", aspectFilename,
+		"-weave-deps=greeter,net/url", "-o", bin, pkg}
+	t.Logf("Running AspectGo with: %s", args[1:])
+	if exitCode := agcli.Main(args); exitCode != 0 {
+		t.Fatalf("aspectgo build failed with exit code %d", exitCode)
+	}
+	out, err := exec.Command(bin).CombinedOutput()
+	t.Logf("Test Result:\n%s", string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "ADVICE [hello world]\n" +
+		"hello world\n" +
+		"ADVICE [http://example.com/foo false]\n" +
+		"example.com\n"
+	if string(out) != expected {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExConfig(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "config")
+	aspectFilename := filepath.Join(GOPATH, "src", pkg, "main_aspect.go")
+	outDir, err := ioutil.TempDir("", "agtestbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	bin := filepath.Join(outDir, "config")
+	args := []string{"aspectgo", "build", "-a", aspectFilename, "-o", bin, pkg}
+	t.Logf("Running AspectGo with: %s", args[1:])
+	if exitCode := agcli.Main(args); exitCode != 0 {
+		t.Fatalf("aspectgo build failed with exit code %d", exitCode)
+	}
+	configFile := filepath.Join(outDir, "config.json")
+	config := `{"TraceAspect": {"enabled": false}, "FaultAspect": {"config": {"message": "disk full"}}}`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		env      []string
+		expected string
+	}{
+		{nil, "TRACE [world]\nhello world\nopen: injected fault\n"},
+		{[]string{"ASPECTGO_ENABLE=-FaultAspect"}, "TRACE [world]\nhello world\nopen: <nil>\n"},
+		{[]string{"ASPECTGO_ENABLE=-*"}, "hello world\nopen: <nil>\n"},
+		{[]string{"ASPECTGO_CONFIG=" + configFile}, "hello world\nopen: disk full\n"},
+		{[]string{"ASPECTGO_CONFIG=" + configFile, "ASPECTGO_ENABLE=TraceAspect"}, "TRACE [world]\nhello world\nopen: disk full\n"},
+	}
+	for _, tc := range testCases {
+		cmd := exec.Command(bin)
+		cmd.Env = append(os.Environ(), tc.env...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v: %s", tc.env, err, out)
+		}
+		if string(out) != tc.expected {
+			t.Fatalf("%v: unexpected output: %q", tc.env, out)
+		}
+	}
+}
+
 func TestExRecursive(t *testing.T) {
 	testEx(t, "recursive", "main.go", "main_aspect.go", true)
 }
+
+func TestExHelloTest(t *testing.T) {
+	out1, out2 := testExGoTest(t, "hellotest", "hellotest_aspect.go")
+	if bytes.Contains(out1, []byte("BEFORE Hello")) {
+		t.Fatal("original test should not be woven")
+	}
+	// both the internal test and the external test should be woven
+	if n := bytes.Count(out2, []byte("BEFORE Hello")); n != 2 {
+		t.Fatalf("expected 2 advice executions, got %d", n)
+	}
+}
+
+// execAspectGoCommand executes the aspectgo command in a new process, and
+// returns the output including stderr.
+func execAspectGoCommand(t *testing.T, args ...string) ([]byte, error) {
+	t.Logf("Running AspectGo with: %s", args)
+	cmd := exec.Command("go", append([]string{"run", filepath.Join(exPackage, "..", "cmd", "aspectgo")}, args...)...)
+	cmd.Env = envWithGOPATH(GOPATH)
+	out, err := cmd.CombinedOutput()
+	t.Logf("Result:\n%s", string(out))
+	return out, err
+}
+
+func TestExHelloTestSubcommand(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "hellotest")
+	aspectFilename := filepath.Join(GOPATH, "src", pkg, "hellotest_aspect.go")
+	out, err := execAspectGoCommand(t, "test", "-a", aspectFilename, "-v", "-run", "Ext", pkg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// -v and -run are passed through to `go test`
+	if !bytes.Contains(out, []byte("=== RUN   TestHelloExt\n")) ||
+		bytes.Contains(out, []byte("=== RUN   TestHello\n")) {
+		t.Fatalf("unexpected output: %q", out)
+	}
+}
+
+func TestExHelloRunSubcommand(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "runargs")
+	aspectFilename := filepath.Join(GOPATH, "src", pkg, "main_aspect.go")
+	// -gcflags takes the value, and the arguments after the package are
+	// passed to the program
+	out, err := execAspectGoCommand(t, "run", "-a", aspectFilename, "-gcflags", "-N", pkg, "-v", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "BEFORE greet [-v]\nhello -v\nBEFORE greet [world]\nhello world\n"
+	if !bytes.Contains(out, []byte(expected)) {
+		t.Fatalf("%q not found in the output: %q", expected, out)
+	}
+}
+
+func TestExCleanSubcommand(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "hello")
+	wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wovenGOPATH)
+	if err := execAspectGo(t, wovenGOPATH, pkg, "main_aspect.go", false); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := agcli.Main([]string{"aspectgo", "clean", "-w", wovenGOPATH}); exitCode != 0 {
+		t.Fatalf("aspectgo clean failed with exit code %d", exitCode)
+	}
+	if _, err := os.Stat(wovenGOPATH); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed: %v", wovenGOPATH, err)
+	}
+	// a directory that is not a woven GOPATH is never removed
+	if exitCode := agcli.Main([]string{"aspectgo", "clean", "-w", filepath.Join(GOPATH, "src", pkg)}); exitCode == 0 {
+		t.Fatal("expected aspectgo clean to fail")
+	}
+}
+
+// TestExWovenGOPATHReuse checks that two packages can be woven into the same
+// woven GOPATH, without modifying the original files.
+func TestExWovenGOPATHReuse(t *testing.T) {
+	t.Parallel()
+	wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wovenGOPATH)
+	pkgs := []string{filepath.Join(exPackage, "hello"), filepath.Join(exPackage, "hello2")}
+	origs := make(map[string][]byte)
+	for _, pkg := range pkgs {
+		f := filepath.Join(GOPATH, "src", pkg, "main.go")
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		origs[f] = b
+		if err := execAspectGo(t, wovenGOPATH, pkg, "main_aspect.go", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, pkg := range pkgs {
+		out, err := execMainWithGOPATH(t, wovenGOPATH, pkg, "main.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(out, []byte("BEFORE")) {
+			t.Fatalf("%s: expected the advice to be executed: %q", pkg, out)
+		}
+	}
+	for f, orig := range origs {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, orig) {
+			t.Fatalf("%s is modified", f)
+		}
+	}
+}
+
+func TestExHelloBuildSubcommand(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "hello")
+	aspectFilename := filepath.Join(GOPATH, "src", pkg, "main_aspect.go")
+	outDir, err := ioutil.TempDir("", "agtestbin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+	bin := filepath.Join(outDir, "hello")
+	args := []string{"aspectgo", "build", "-a", aspectFilename, "-o", bin, pkg}
+	t.Logf("Running AspectGo with: %s", args[1:])
+	if exitCode := agcli.Main(args); exitCode != 0 {
+		t.Fatalf("aspectgo build failed with exit code %d", exitCode)
+	}
+	out, err := exec.Command(bin).CombinedOutput()
+	t.Logf("Test Result:\n%s", string(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("BEFORE hello")) {
+		t.Fatal("woven binary should execute the advice")
+	}
+}
+
+func TestExHello2Plan(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "hello2")
+	comp := compiler.Compiler{
+		Target:          pkg,
+		AspectFilenames: []string{filepath.Join(GOPATH, "src", pkg, "main_aspect.go")},
+	}
+	jps, err := comp.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		position string
+		callee   string
+		aspect   string
+	}{
+		{filepath.Join(GOPATH, "src", pkg, "main.go") + ":8:2", "fmt.Println", "FmtPrintlnAspect"},
+		{filepath.Join(GOPATH, "src", pkg, "main.go") + ":12:2", pkg + ".sayHello", "ExampleAspect"},
+	}
+	if len(jps) != len(expected) {
+		t.Fatalf("expected %d join points, got %+v", len(expected), jps)
+	}
+	for i, jp := range jps {
+		t.Logf("%s: %s (%s)", jp.Position, jp.Callee, jp.Aspect)
+		if jp.Position.String() != expected[i].position ||
+			jp.Callee != expected[i].callee || jp.Aspect != expected[i].aspect {
+			t.Fatalf("expected %+v, got %+v", expected[i], jp)
+		}
+	}
+}
+
+// TestExHello2Reproducible checks that weaving the same package twice
+// results in the identical woven files.
+func TestExHello2Reproducible(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "hello2")
+	var woven [][]byte
+	for i := 0; i < 2; i++ {
+		wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(wovenGOPATH)
+		if err = execAspectGo(t, wovenGOPATH, pkg, "main_aspect.go", false); err != nil {
+			t.Fatal(err)
+		}
+		var b []byte
+		for _, f := range []string{"main.go", "zz_aspectgo_proxies.go"} {
+			fb, err := ioutil.ReadFile(filepath.Join(wovenGOPATH, "src", pkg, f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b = append(b, fb...)
+		}
+		woven = append(woven, b)
+	}
+	if !bytes.Equal(woven[0], woven[1]) {
+		t.Fatalf("woven files differ:\n%s\n----\n%s", woven[0], woven[1])
+	}
+	if !bytes.Contains(woven[0], []byte("_ag_proxy_")) {
+		t.Fatalf("no proxy found in the woven files:\n%s", woven[0])
+	}
+}
+
+// tmpAspectTmpl is the template for tmpAspectFile.
+const tmpAspectTmpl = `package main
+
+import (
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+type TmpAspect struct {
+}
+
+func (a *TmpAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(%q)
+}
+
+func (a *TmpAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.Call(ctx.Args())
+}
+`
+
+// tmpAspectFile creates an aspect file with the pointcut in dir.
+func tmpAspectFile(t *testing.T, dir, pointcut string) string {
+	aspectFilename := filepath.Join(dir, "tmp_aspect.go")
+	cont := fmt.Sprintf(tmpAspectTmpl, pointcut)
+	if err := ioutil.WriteFile(aspectFilename, []byte(cont), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return aspectFilename
+}
+
+func TestExHelloStrict(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "agtestaspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comp := compiler.Compiler{
+		Target:          filepath.Join(exPackage, "hello"),
+		AspectFilenames: []string{tmpAspectFile(t, dir, "sayHelo")},
+	}
+	if _, err = comp.Plan(); err != nil {
+		t.Fatalf("unmatched pointcut should be just a warning: %v", err)
+	}
+	comp.Strict = true
+	_, err = comp.Plan()
+	t.Logf("error (expected): %v", err)
+	if err == nil || !strings.Contains(err.Error(), "TmpAspect") {
+		t.Fatal("unmatched pointcut should be an error in the strict mode")
+	}
+}
+
+// TestExTypeaccess checks that weaving a join point whose signature refers to
+// an unexported type or a type of an internal package fails with ErrType,
+// rather than generating the woven code that does not compile.
+func TestExTypeaccess(t *testing.T) {
+	t.Parallel()
+	pkg := filepath.Join(exPackage, "typeaccess")
+	dir, err := ioutil.TempDir("", "agtestaspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testCases := []struct {
+		aspectFilename string
+		expected       string
+	}{
+		{filepath.Join(GOPATH, "src", pkg, "main_aspect.go"),
+			"main.go:10:18: type error: cannot refer to " + pkg + "/lib.impl in the proxy: not exported"},
+		{tmpAspectFile(t, dir, regexp.QuoteMeta(pkg+"/lib.NewSecret")),
+			"main.go:11:18: type error: cannot refer to " + pkg + "/lib/internal/secret.Secret in the proxy: use of internal package"},
+	}
+	for _, tc := range testCases {
+		wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(wovenGOPATH)
+		comp := compiler.Compiler{
+			WovenGOPATH:     wovenGOPATH,
+			Target:          pkg,
+			AspectFilenames: []string{tc.aspectFilename},
+		}
+		err = comp.Do()
+		t.Logf("error (expected): %v", err)
+		werr, ok := err.(*weave.Error)
+		if !ok || werr.Category != weave.ErrType {
+			t.Fatalf("expected *weave.Error with ErrType, got %v", err)
+		}
+		if !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected %q, got %q", tc.expected, err)
+		}
+	}
+}
+
+func TestExHelloInvalidPointcut(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "agtestaspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	aspectFilename := tmpAspectFile(t, dir, "sayHello(")
+	comp := compiler.Compiler{
+		Target:          filepath.Join(exPackage, "hello"),
+		AspectFilenames: []string{aspectFilename},
+	}
+	_, err = comp.Plan()
+	t.Logf("error (expected): %v", err)
+	if err == nil {
+		t.Fatal("invalid pointcut should be an error")
+	}
+	// the position of TmpAspect.Pointcut()
+	expected := fmt.Sprintf("%s:10:21: invalid pointcut", aspectFilename)
+	if !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected %q, got %q", expected, err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+)
+
+func read(name string) (string, error) {
+	return "content of " + name, nil
+}
+
+func count() int {
+	return 42
+}
+
+func check() {
+}
+
+func main() {
+	for i := 0; i < 3; i++ {
+		fmt.Println(read("foo"))
+	}
+	for i := 0; i < 3; i++ {
+		fmt.Println(count())
+	}
+	func() {
+		defer func() {
+			fmt.Println("recovered:", recover())
+		}()
+		check()
+	}()
+}
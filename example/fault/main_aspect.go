@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/aspect/lib/fault"
+)
+
+const pkg = "github.com/AkihiroSuda/aspectgo/example/fault"
+
+// FaultAspect injects faults with the fault-injection library.
+type FaultAspect struct {
+	fault.Aspect
+}
+
+func (a *FaultAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(regexp.QuoteMeta(pkg) + `\..*`)
+}
+
+func init() {
+	fault.Set(pkg+".read", fault.Rule{
+		Action: fault.ReturnError,
+		Err:    errors.New("disk full"),
+		After:  1,
+	})
+	if err := fault.SetSpec(pkg+".count", "every(2) corrupt(0=-1)"); err != nil {
+		panic(err)
+	}
+	fault.Set(pkg+".check", fault.Rule{Action: fault.Panic})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Number is the constraint for Sum.
+type Number interface {
+	~int | ~float64
+}
+
+func Sum[T Number](xs ...T) T {
+	var sum T
+	for _, x := range xs {
+		sum += x
+	}
+	return sum
+}
+
+func Zero[T any]() T {
+	var zero T
+	return zero
+}
+
+// ZeroOf calls Zero instantiated with the type parameter T.
+// The call cannot be woven, and it is skipped with a warning.
+func ZeroOf[T any](T) T {
+	return Zero[T]()
+}
+
+// Pair is instantiated with multiple type arguments.
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(x T) {
+	s.items = append(s.items, x)
+}
+
+func (s *Stack[T]) Len() int {
+	return len(s.items)
+}
+
+func main() {
+	fmt.Println(Sum(1, 2, 3))
+	fmt.Println(Sum[float64](1.5, 2.5))
+	fmt.Printf("%q\n", Zero[string]())
+	s := &Stack[int]{}
+	s.Push(42)
+	fmt.Println(s.Len())
+	fmt.Println(ZeroOf(42))
+	fmt.Println(Pair[string, int]{"answer", 42})
+}
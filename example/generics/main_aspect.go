@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// SumAspect implements interface asp.Aspect
+type SumAspect struct {
+}
+
+// Executed on compilation-time
+func (a *SumAspect) Pointcut() asp.Pointcut {
+	// only Sum[int] matches; Sum[float64] does not
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/generics")
+	s := pkg + regexp.QuoteMeta(".Sum[int]")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *SumAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("ADVICE %s\n", ctx.CallSite().Callee)
+	return ctx.Call(ctx.Args())
+}
+
+// GenericAspect implements interface asp.Aspect
+type GenericAspect struct {
+}
+
+// Executed on compilation-time
+func (a *GenericAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/generics")
+	s := pkg + "(" + regexp.QuoteMeta(".Zero") + "|" + regexp.QuoteMeta(".Stack[T]).Push") + ")"
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *GenericAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("ADVICE %s\n", ctx.CallSite().Callee)
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func fetch(ctx context.Context, key string) string {
+	if _, ok := ctx.Deadline(); ok {
+		return key + " (with deadline)"
+	}
+	return key + " (without deadline)"
+}
+
+func main() {
+	fmt.Println(fetch(context.Background(), "foo"))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/gocontext")
+	s := pkg + `\.fetch$`
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	c, ok := ctx.GoContext()
+	if !ok {
+		return ctx.Call(ctx.Args())
+	}
+	fmt.Printf("ADVICE %v\n", c)
+	c, cancel := context.WithTimeout(c, time.Minute)
+	defer cancel()
+	return ctx.Call(ctx.WithGoContext(ctx.Args(), c))
+}
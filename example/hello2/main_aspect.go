@@ -40,5 +40,6 @@ func (a *FmtPrintlnAspect) Pointcut() asp.Pointcut {
 func (a *FmtPrintlnAspect) Advice(ctx asp.Context) []interface{} {
 	args := ctx.Args()
 	fmt.Fprintf(os.Stderr, "directing to stderr: %s\n", args...)
-	return []interface{}{0, nil}
+	// skip the call
+	return ctx.ZeroResults()
 }
@@ -0,0 +1,7 @@
+// Package hellotest is an example for weaving _test.go files.
+package hellotest
+
+// Hello returns the greeting for s.
+func Hello(s string) string {
+	return "hello " + s
+}
@@ -0,0 +1,31 @@
+//go:build ignore
+// +build ignore
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := "github.com/AkihiroSuda/aspectgo/example/hellotest"
+	s := regexp.QuoteMeta(pkg + ".Hello")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	args := ctx.Args()
+	fmt.Println("BEFORE Hello")
+	res := ctx.Call(args)
+	fmt.Println("AFTER Hello")
+	return res
+}
@@ -0,0 +1,12 @@
+package hellotest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AkihiroSuda/aspectgo/example/hellotest"
+)
+
+func TestHelloExt(t *testing.T) {
+	fmt.Println(hellotest.Hello("external test"))
+}
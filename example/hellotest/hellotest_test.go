@@ -0,0 +1,10 @@
+package hellotest
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHello(t *testing.T) {
+	fmt.Println(Hello("internal test"))
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+)
+
+// aspectrt and agaspect shadow the import names of the woven files,
+// if they are not chosen uniquely.
+var aspectrt = "aspectrt"
+
+type agaspect struct{}
+
+func (agaspect) String() string {
+	return "agaspect"
+}
+
+func sayHello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func main() {
+	sayHello(aspectrt)
+	sayHello(agaspect{}.String())
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/importnames")
+	s := pkg + `\.sayHello$`
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Println("BEFORE hello")
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+type counter struct {
+	name string
+}
+
+func (c *counter) inc() {
+	fmt.Println("inc " + c.name)
+}
+
+func hello() {
+	fmt.Println("hello")
+}
+
+func world() {
+	fmt.Println("world")
+}
+
+func greet() {
+	fmt.Println("greet")
+}
+
+func main() {
+	hello()
+	hello()
+
+	a, b := &counter{"a"}, &counter{"b"}
+	a.inc()
+	b.inc()
+	a.inc()
+
+	world()
+	world()
+
+	greet()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		greet()
+		greet()
+	}()
+	wg.Wait()
+	greet()
+}
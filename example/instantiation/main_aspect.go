@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+var pkg = regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/instantiation")
+
+// count counts the calls and prints the count before the call.
+func count(n *int, name string, ctx asp.Context) []interface{} {
+	*n++
+	fmt.Printf("%s %d\n", name, *n)
+	return ctx.Call(ctx.Args())
+}
+
+// SingletonAspect counts all the calls to hello().
+// Singleton is the default instantiation policy.
+type SingletonAspect struct {
+	n int
+}
+
+func (a *SingletonAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.hello$`)
+}
+
+func (a *SingletonAspect) Advice(ctx asp.Context) []interface{} {
+	return count(&a.n, "singleton", ctx)
+}
+
+// PerThisAspect counts the calls to counter.inc() for each counter.
+type PerThisAspect struct {
+	n int
+}
+
+func (a *PerThisAspect) Instantiation() asp.Instantiation {
+	return asp.PerThis
+}
+
+func (a *PerThisAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(`\(\*` + pkg + `\.counter\)\.inc$`)
+}
+
+func (a *PerThisAspect) Advice(ctx asp.Context) []interface{} {
+	return count(&a.n, "perthis", ctx)
+}
+
+// PerCallAspect is instantiated for each call to world().
+type PerCallAspect struct {
+	n int
+}
+
+func (a *PerCallAspect) Instantiation() asp.Instantiation {
+	return asp.PerCall
+}
+
+func (a *PerCallAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.world$`)
+}
+
+func (a *PerCallAspect) Advice(ctx asp.Context) []interface{} {
+	return count(&a.n, "percall", ctx)
+}
+
+// PerGoroutineAspect counts the calls to greet() for each goroutine.
+type PerGoroutineAspect struct {
+	n int
+}
+
+func (a *PerGoroutineAspect) Instantiation() asp.Instantiation {
+	return asp.PerGoroutine
+}
+
+func (a *PerGoroutineAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.greet$`)
+}
+
+func (a *PerGoroutineAspect) Advice(ctx asp.Context) []interface{} {
+	return count(&a.n, "pergoroutine", ctx)
+}
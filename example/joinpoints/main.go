@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/AkihiroSuda/aspectgo/aspect/debug"
+)
+
+func sayHello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func main() {
+	sayHello("world")
+	sayHello("aspectgo")
+	// the join points can also be served with debug.Handler(), or dumped
+	// with debug.DumpOnSignal()
+	debug.WriteJoinPoints(os.Stdout)
+}
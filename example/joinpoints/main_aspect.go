@@ -0,0 +1,23 @@
+package main
+
+import (
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/joinpoints")
+	s := pkg + `\.sayHello$`
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// where returns the position of the caller.
+// The position is expected to be same before and after weaving.
+func where() string {
+	_, file, line, _ := runtime.Caller(1)
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
+/*
+ * The comments below would shift the line numbers in the woven file,
+ * if the woven file did not have //line directives.
+ */
+
+func sayHello(s string) {
+	// this is a comment
+	fmt.Println("hello " + s + " at " + where())
+}
+
+// main is the entry point.
+//
+// The woven file has additional lines for importing the aspect,
+// which would shift the line numbers as well.
+func main() {
+	sayHello("world")
+	fmt.Println("main at " + where())
+}
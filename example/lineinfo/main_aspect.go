@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/lineinfo")
+	s := pkg + regexp.QuoteMeta(".sayHello")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	// the caller of Advice is the generated proxy, which is mapped to this method
+	_, file, _, _ := runtime.Caller(1)
+	fmt.Printf("ADVICE called from %s\n", file)
+	return ctx.Call(ctx.Args())
+}
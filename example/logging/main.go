@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func div(a, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func mustPositive(n int) int {
+	if n <= 0 {
+		panic("not positive")
+	}
+	return n
+}
+
+func main() {
+	fmt.Println(div(6, 3))
+	fmt.Println(div(6, 0))
+	func() {
+		defer func() {
+			fmt.Println("recovered:", recover())
+		}()
+		mustPositive(-1)
+	}()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"os"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+	"github.com/AkihiroSuda/aspectgo/aspect/lib/logging"
+)
+
+// LogAspect logs the calls with the logging library.
+type LogAspect struct {
+	logging.Aspect
+}
+
+func (a *LogAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/logging")
+	return asp.NewCallPointcutFromRegexp(pkg + `\.(div|mustPositive)$`)
+}
+
+func init() {
+	// the duration is disabled for the reproducible output
+	logging.SetDefaults(logging.Options{
+		Entry:   true,
+		Exit:    true,
+		Args:    true,
+		Results: true,
+		Sink:    &logging.LogSink{Logger: log.New(os.Stdout, "", 0)},
+	})
+}
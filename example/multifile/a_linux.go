@@ -0,0 +1,11 @@
+package main
+
+import (
+	"fmt"
+)
+
+// platform is defined in the files with build constraints.
+// The file name begins with "a_" so that it is woven first.
+func platform() {
+	fmt.Println("platform")
+}
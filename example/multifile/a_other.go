@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+)
+
+func platform() {
+	fmt.Println("platform")
+}
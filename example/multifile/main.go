@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+)
+
+func main() {
+	// fmt is used only for the woven call
+	fmt.Println("main")
+	greet()
+	platform()
+}
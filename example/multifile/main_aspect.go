@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(`^fmt\.Println$`)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("ADVICE %s\n", ctx.CallSite())
+	return ctx.Call(ctx.Args())
+}
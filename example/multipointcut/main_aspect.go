@@ -14,7 +14,7 @@ type Aspect1 struct {
 
 func (a *Aspect1) Pointcut() asp.Pointcut {
 	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/multipointcut")
-	s := regexp.QuoteMeta(pkg + ".sayHello")
+	s := pkg + regexp.QuoteMeta(".sayHello")
 	return asp.NewCallPointcutFromRegexp(s)
 }
 
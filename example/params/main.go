@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+func describe(int, string) string {
+	return "described"
+}
+
+func ignore(_ int) {
+	fmt.Println("ignored")
+}
+
+func fail() (err error) {
+	err = errors.New("failed")
+	return
+}
+
+// collide has the parameters that collide with the identifiers in the proxy.
+func collide(_ag_res string, bytes *bytes.Buffer, string int) {
+	fmt.Fprintf(bytes, "%s %d", _ag_res, string)
+}
+
+func main() {
+	fmt.Println(describe(1, "a"))
+	ignore(2)
+	fmt.Println(fail())
+	var b bytes.Buffer
+	collide("collided", &b, 3)
+	fmt.Println(b.String())
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func greet(name string) {
+	fmt.Println("hello " + name)
+}
+
+func main() {
+	for _, arg := range os.Args[1:] {
+		greet(arg)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func divide(a, b int64) (int64, error) {
+	if b == 0 {
+		// the aspect makes it an error
+		return 0, nil
+	}
+	return a / b, nil
+}
+
+func timeout(name string) (time.Duration, bool) {
+	return 0, false
+}
+
+func sayHello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func main() {
+	fmt.Println(divide(6, 3))
+	fmt.Println(divide(6, 0))
+	fmt.Println(timeout("foo"))
+	sayHello("world")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+var pkg = regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/skipcall")
+
+// DivideAspect returns an error for division by zero.
+type DivideAspect struct {
+}
+
+func (a *DivideAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.divide$`)
+}
+
+func (a *DivideAspect) Advice(ctx asp.Context) []interface{} {
+	if ctx.Args()[1] == int64(0) {
+		return ctx.ReturnError(errors.New("division by zero"))
+	}
+	return ctx.Call(ctx.Args())
+}
+
+// TimeoutAspect returns the fixed timeout.
+type TimeoutAspect struct {
+}
+
+func (a *TimeoutAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.timeout$`)
+}
+
+func (a *TimeoutAspect) Advice(ctx asp.Context) []interface{} {
+	// converted to time.Duration
+	return ctx.Results(1000000000, true)
+}
+
+// SkipAspect skips the call.
+type SkipAspect struct {
+}
+
+func (a *SkipAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(pkg + `\.sayHello$`)
+}
+
+func (a *SkipAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.ZeroResults()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+func sayHello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func main() {
+	sayHello("enabled")
+	// the errors are printed when the aspect is not woven
+	if err := aspect.Disable("ExampleAspect"); err != nil {
+		fmt.Println(err)
+	}
+	sayHello("disabled")
+	if err := aspect.Enable("ExampleAspect"); err != nil {
+		fmt.Println(err)
+	}
+	sayHello("enabled again")
+}
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"github.com/AkihiroSuda/aspectgo/example/typeaccess/lib/internal/secret"
+)
+
+type impl struct {
+	name string
+}
+
+func (i *impl) Name() string {
+	return i.name
+}
+
+// New returns the unexported type.
+func New(name string) *impl {
+	return &impl{name: name}
+}
+
+// NewSecret returns the type of the internal package.
+func NewSecret() secret.Secret {
+	return secret.Secret("42")
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/AkihiroSuda/aspectgo/example/typeaccess/lib"
+)
+
+func main() {
+	fmt.Println(lib.New("foo").Name())
+	fmt.Println(lib.NewSecret())
+}
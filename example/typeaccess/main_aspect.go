@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect matches the functions whose results cannot be referred from
+// the main package. Weaving the aspect fails with weave.ErrType.
+type ExampleAspect struct {
+}
+
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/typeaccess/lib")
+	return asp.NewCallPointcutFromRegexp(pkg + `\.New`)
+}
+
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,12 @@
+package lib
+
+import (
+	"bytes"
+
+	"github.com/AkihiroSuda/aspectgo/example/typeimports/lib/libx"
+)
+
+// Hello returns the greeting for s.
+func Hello(s libx.Name) *bytes.Buffer {
+	return bytes.NewBufferString("hello " + string(s))
+}
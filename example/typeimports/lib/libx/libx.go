@@ -0,0 +1,4 @@
+package libx
+
+// Name is a name.
+type Name string
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/AkihiroSuda/aspectgo/example/typeimports/lib"
+)
+
+// bytes shadows the package name of the return type of lib.Hello
+var bytes = 42
+
+func main() {
+	fmt.Println(lib.Hello("world").String(), bytes)
+}
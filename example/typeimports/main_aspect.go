@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("github.com/AkihiroSuda/aspectgo/example/typeimports/lib")
+	s := pkg + regexp.QuoteMeta(".Hello")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	// the signature of lib.Hello refers to the packages
+	// that are not imported in main.go
+	fmt.Println("BEFORE hello")
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"greeter"
+)
+
+func main() {
+	fmt.Println(greeter.Greet("world"))
+	u, err := url.Parse("http://example.com/foo")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(u.Host)
+}
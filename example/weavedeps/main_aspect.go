@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+
+	asp "github.com/AkihiroSuda/aspectgo/aspect"
+)
+
+// ExampleAspect implements interface asp.Aspect
+type ExampleAspect struct {
+}
+
+// Executed on compilation-time
+// The calls are made inside the dependency packages, so -weave-deps is needed.
+func (a *ExampleAspect) Pointcut() asp.Pointcut {
+	s := `(/greeter\.format|^net/url\.parse)$`
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Executed ONLY on runtime
+func (a *ExampleAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("ADVICE %v\n", ctx.Args())
+	return ctx.Call(ctx.Args())
+}